@@ -0,0 +1,169 @@
+package imageResizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+// animation is animated GIF image. Where single image is expected
+// it acts as its first frame.
+type animation struct {
+	image.Image
+	// full frames composed according to disposal methods
+	frames    []image.Image
+	palettes  []color.Palette
+	delay     []int
+	loopCount int
+}
+
+// Returns number of frames of GIF file. Blocks of the file are only
+// skipped, so frames are counted without decoding them.
+func gifFrames(data []byte) (int, error) {
+	errFormat := errors.New("gif: invalid block structure")
+	// header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, errFormat
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		// global color table
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skips sub-blocks, the last one is empty
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: label and sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return 0, errFormat
+			}
+		case 0x2C: // image descriptor, color table, LZW code size and sub-blocks
+			if pos+10 > len(data) {
+				return 0, errFormat
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if !skipSubBlocks() {
+				return 0, errFormat
+			}
+			frames++
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return 0, errFormat
+		}
+	}
+	// decoder accepts files without trailer
+	return frames, nil
+}
+
+// Returns animation with full frames of decoded GIF.
+func newAnimation(g *gif.GIF) *animation {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		bounds = g.Image[0].Bounds()
+	}
+
+	a := &animation{
+		delay:     g.Delay,
+		loopCount: g.LoopCount,
+	}
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		a.frames = append(a.frames, cloneRGBA(canvas))
+		a.palettes = append(a.palettes, frame.Palette)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	a.Image = a.frames[0]
+
+	return a
+}
+
+// Returns animation with every frame resized according to the preset.
+func (a *animation) resize(ctx context.Context, p Preset) (*animation, error) {
+	if p.Crop == CropEntropy {
+		// area is chosen once by the first frame, so it doesn't jump between frames
+		r := cropRect(a.Image, p)
+		b := a.Image.Bounds()
+		p.Crop = CropFocal
+		p.Focus = fmt.Sprintf("%f,%f",
+			float64(r.Min.X-b.Min.X+r.Dx()/2)/float64(b.Dx()),
+			float64(r.Min.Y-b.Min.Y+r.Dy()/2)/float64(b.Dy()))
+	}
+
+	resized := &animation{
+		palettes:  a.palettes,
+		delay:     a.delay,
+		loopCount: a.loopCount,
+	}
+	for _, frame := range a.frames {
+		img, err := resizeImage(ctx, frame, p)
+		if err != nil {
+			return nil, err
+		}
+		resized.frames = append(resized.frames, img)
+	}
+	resized.Image = resized.frames[0]
+
+	return resized, nil
+}
+
+// Returns GIF ready for encoding. Every frame is full, so it's
+// disposed to background before the next one is drawn.
+func (a *animation) gif() *gif.GIF {
+	g := &gif.GIF{
+		Delay:     a.delay,
+		LoopCount: a.loopCount,
+	}
+	for i, frame := range a.frames {
+		paletted := image.NewPaletted(frame.Bounds(), a.palettes[i])
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, frame.Bounds().Min)
+		g.Image = append(g.Image, paletted)
+		g.Disposal = append(g.Disposal, gif.DisposalBackground)
+	}
+	return g
+}
+
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := image.NewRGBA(img.Bounds())
+	copy(clone.Pix, img.Pix)
+	return clone
+}
@@ -0,0 +1,91 @@
+package imageResizer
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+func TestImageResizer_animatedGIF(t *testing.T) {
+	ir, err := imageResizerFromImagePath("testdata/GIFImage.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if _, ok := ir.originalImg.(*animation); !ok {
+		t.Fatalf("Got %T, but expected animation", ir.originalImg)
+	}
+
+	img, err := ir.GetVariant(Preset{Name: "small", Width: 80, Height: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, img, Preset{Format: GIF}); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 {
+		t.Fatalf("Got %v frames, but expected %v", len(g.Image), 3)
+	}
+	for i, frame := range g.Image {
+		if size := frame.Bounds().Size(); size.X != 80 || size.Y != 40 {
+			t.Errorf("Frame %v: got %vx%v, but expected 80x40", i, size.X, size.Y)
+		}
+	}
+}
+
+func TestSetOutputFormats(t *testing.T) {
+	s := newService()
+
+	tests := []struct {
+		name    string
+		formats map[string]string
+		wantErr bool
+	}{
+		{"webp to png", map[string]string{WEBP: PNG}, false},
+		{"webp and bmp to jpeg", map[string]string{WEBP: JPEG, BMP: JPEG}, false},
+		{"webp kept", map[string]string{}, true},
+		{"to webp", map[string]string{WEBP: PNG, PNG: WEBP}, true},
+		{"unknown input", map[string]string{WEBP: PNG, "psd": PNG}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetOutputFormats(tt.formats); (err != nil) != tt.wantErr {
+				t.Errorf("Got error %v, but expected error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := s.SetOutputFormats(map[string]string{WEBP: PNG, BMP: JPEG}); err != nil {
+		t.Fatal(err)
+	}
+	for input, want := range map[string]string{WEBP: PNG, BMP: JPEG, GIF: GIF, TIFF: TIFF} {
+		if got := s.outputFormat(input); got != want {
+			t.Errorf("%s: got %v, but expected %v", input, got, want)
+		}
+	}
+}
+
+func TestGIFFrames(t *testing.T) {
+	data := readTestFile(t, "testdata/GIFImage.gif")
+	frames, err := gifFrames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != len(g.Image) {
+		t.Errorf("Got %v frames, but expected %v", frames, len(g.Image))
+	}
+
+	if _, err := gifFrames(data[:len(data)/2]); err == nil {
+		t.Error("Expected error for truncated file")
+	}
+}
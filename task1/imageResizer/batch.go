@@ -0,0 +1,144 @@
+package imageResizer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Sets maximum size of batch request in megabytes and maximum
+// number of images in it.
+func (s *Service) SetBatchLimits(maxSize int64, maxFiles int) {
+	s.maxBatchSize = maxSize
+	s.maxBatchFiles = maxFiles
+}
+
+// BatchItem is result of one file of batch upload, either Result or Error is set.
+type BatchItem struct {
+	File   string         `json:"file"`
+	Result *Result        `json:"result,omitempty"`
+	Error  *ErrorResponse `json:"error,omitempty"`
+}
+
+// BatchResult is response of batch upload, items are in order files were sent.
+type BatchResult struct {
+	Items []BatchItem `json:"items"`
+}
+
+// batchFile is one image of the batch, uploaded directly or found in zip archive.
+type batchFile struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// batch is uploaded images, zip archives it was read from
+// are open until it's closed.
+type batch struct {
+	files    []batchFile
+	archives []io.Closer
+}
+
+var zipMagic = []byte("PK\x03\x04")
+
+// Returns batch of uploaded files, zip archives are expanded.
+func newBatch(headers []*multipart.FileHeader) (*batch, error) {
+	b := &batch{}
+	for _, header := range headers {
+		header := header
+		file, err := header.Open()
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		magic := make([]byte, len(zipMagic))
+		n, _ := file.ReadAt(magic, 0)
+		if n < len(magic) || !bytes.Equal(magic, zipMagic) {
+			file.Close()
+			b.files = append(b.files, batchFile{
+				name: header.Filename,
+				size: header.Size,
+				open: func() (io.ReadCloser, error) { return header.Open() },
+			})
+			continue
+		}
+
+		b.archives = append(b.archives, file)
+		archive, err := zip.NewReader(file, header.Size)
+		if err != nil {
+			b.Close()
+			return nil, fmt.Errorf("%s: %v", header.Filename, err)
+		}
+		for _, entry := range archive.File {
+			entry := entry
+			base := path.Base(entry.Name)
+			if entry.FileInfo().IsDir() || strings.HasPrefix(entry.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+				continue
+			}
+			b.files = append(b.files, batchFile{
+				name: header.Filename + "/" + entry.Name,
+				size: int64(entry.UncompressedSize64),
+				open: entry.Open,
+			})
+		}
+	}
+	return b, nil
+}
+
+func (b *batch) Close() {
+	for _, archive := range b.archives {
+		archive.Close()
+	}
+}
+
+// Processes files by batchWorkers goroutines, failure of one file
+// doesn't stop the others.
+func (s *Service) processBatch(ctx context.Context, b *batch, presets []Preset) *BatchResult {
+	result := &BatchResult{Items: make([]BatchItem, len(b.files))}
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, s.batchWorkers)
+	for i, f := range b.files {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, f batchFile) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			item := BatchItem{File: f.name}
+			res, err := s.processBatchFile(ctx, f, presets)
+			if err != nil {
+				_, code := errorStatus(err)
+				countError(code)
+				item.Error = &ErrorResponse{Code: code, Message: err.Error()}
+			} else {
+				item.Result = s.signResult(res)
+			}
+			result.Items[i] = item
+		}(i, f)
+	}
+	wg.Wait()
+
+	return result
+}
+
+func (s *Service) processBatchFile(ctx context.Context, f batchFile, presets []Preset) (*Result, error) {
+	file, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ir, err := s.newImageResizer(ctx, file, path.Base(f.name), f.size)
+	if err != nil {
+		return nil, err
+	}
+	defer ir.Close()
+	return ir.SavePresets(presets)
+}
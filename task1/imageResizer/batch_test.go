@@ -0,0 +1,136 @@
+package imageResizer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type batchUpload struct {
+	name string
+	data []byte
+}
+
+func postBatch(t *testing.T, files []batchUpload) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, f := range files {
+		part, _ := writer.CreateFormFile("images", f.name)
+		part.Write(f.data)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/images", body)
+	req.Header.Set("Content-type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	defaultService.BatchProcessingHandler(rec, req)
+	return rec
+}
+
+func readTestFile(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBatchProcessingHandler(t *testing.T) {
+	useTestService(t)
+
+	archive := &bytes.Buffer{}
+	zw := zip.NewWriter(archive)
+	for _, f := range []batchUpload{
+		{"photos/a.gif", readTestFile(t, "testdata/GIFImage.gif")},
+		{"photos/b.bmp", readTestFile(t, "testdata/BMPImage.bmp")},
+		{"photos/.DS_Store", []byte("junk")},
+	} {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	zw.Create("photos/empty/")
+	zw.Close()
+
+	rec := postBatch(t, []batchUpload{
+		{"image.png", readTestFile(t, "testdata/PNGImage.png")},
+		{"notes.txt", []byte("not an image")},
+		{"photos.zip", archive.Bytes()},
+		{"image.jpeg", readTestFile(t, "testdata/JPEGImage.jpeg")},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	result := BatchResult{}
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		file string
+		code string
+	}{
+		{"image.png", ""},
+		{"notes.txt", CodeUnsupportedFormat},
+		{"photos.zip/photos/a.gif", ""},
+		{"photos.zip/photos/b.bmp", ""},
+		{"image.jpeg", ""},
+	}
+	if len(result.Items) != len(want) {
+		t.Fatalf("Got %+v, but expected %d items", result.Items, len(want))
+	}
+	for i, w := range want {
+		item := result.Items[i]
+		if item.File != w.file {
+			t.Errorf("Got %v, but expected %v", item.File, w.file)
+		}
+		if w.code != "" {
+			if item.Error == nil || item.Error.Code != w.code {
+				t.Errorf("Got %+v, but expected error %v", item.Error, w.code)
+			}
+			continue
+		}
+		if item.Error != nil || item.Result == nil || len(item.Result.Variants) != len(defaultService.Presets())+1 {
+			t.Errorf("Got %+v (%+v), but expected saved variants", item.Result, item.Error)
+		}
+	}
+}
+
+func TestBatchProcessingHandler_invalid(t *testing.T) {
+	useTestService(t).SetBatchLimits(1, 2)
+
+	// images are counted before they are decoded
+	small := []byte("small")
+	tests := []struct {
+		name       string
+		files      []batchUpload
+		wantStatus int
+		wantCode   string
+	}{
+		{"no images", nil, http.StatusBadRequest, CodeInvalidRequest},
+		{"too many images", []batchUpload{{"a.png", small}, {"b.png", small}, {"c.png", small}}, http.StatusBadRequest, CodeInvalidRequest},
+		{"too large", []batchUpload{{"a.bin", make([]byte, 3*1024*1024)}}, http.StatusRequestEntityTooLarge, CodeTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postBatch(t, tt.files)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Got %v, but expected %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			resp := ErrorResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("Got %v, but expected %v", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
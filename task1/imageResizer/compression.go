@@ -0,0 +1,135 @@
+package imageResizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+)
+
+// Writes JPEG image of the quality, Exif of the image is kept.
+func encodeJPEG(w io.Writer, img image.Image, quality int, progressive bool) error {
+	var exif []byte
+	if e, ok := img.(*withExif); ok {
+		img, exif = e.Image, e.exif
+	}
+	if exif == nil && !progressive {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if progressive {
+		err = encodeProgressiveJPEG(&buf, img, quality)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if exif != nil {
+		data = insertJpegExif(data, exif)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// MaxSizeError is returned when image of the preset doesn't fit
+// into its MaxSize even at the lowest quality.
+type MaxSizeError struct {
+	Preset  string
+	MaxSize int64
+	// size of image at quality 1
+	Size int64
+}
+
+func (e *MaxSizeError) Error() string {
+	return fmt.Sprintf("Preset %q: image is %d bytes even at quality 1, it doesn't fit into %d bytes!!!", e.Preset, e.Size, e.MaxSize)
+}
+
+// Writes JPEG image of the highest quality up to quality of the preset
+// which fits into MaxSize of the preset. Quality is found by binary search,
+// nothing is written if image doesn't fit even at quality 1.
+func encodeJPEGWithin(w io.Writer, img image.Image, p Preset) error {
+	encode := func(quality int) ([]byte, error) {
+		var buf bytes.Buffer
+		err := encodeJPEG(&buf, img, quality, p.Progressive)
+		return buf.Bytes(), err
+	}
+
+	// most images fit at once
+	best, err := encode(p.jpegQuality())
+	if err != nil {
+		return err
+	}
+	if int64(len(best)) > p.MaxSize {
+		// size of image at the lowest quality which doesn't fit
+		smallest := int64(len(best))
+		low, high := 1, p.jpegQuality()-1
+		best = nil
+		for low <= high {
+			quality := (low + high) / 2
+			data, err := encode(quality)
+			if err != nil {
+				return err
+			}
+			if int64(len(data)) <= p.MaxSize {
+				best = data
+				low = quality + 1
+			} else {
+				smallest = int64(len(data))
+				high = quality - 1
+			}
+		}
+		// search ends at quality 1 if nothing fits
+		if best == nil {
+			return &MaxSizeError{Preset: p.Name, MaxSize: p.MaxSize, Size: smallest}
+		}
+	}
+	_, err = w.Write(best)
+	return err
+}
+
+// Maximum number of colors of paletted PNG image
+const maxPaletteSize = 256
+
+// Returns paletted copy of the image if it has no more than 256 colors,
+// PNG encoder saves it with 1, 2, 4 or 8 bits per pixel instead of 24 or 32.
+// Returns nil if there are more colors or image is already paletted.
+func reducePalette(img image.Image) *image.Paletted {
+	if a, ok := img.(*animation); ok {
+		img = a.Image
+	}
+	if _, ok := img.(*image.Paletted); ok {
+		return nil
+	}
+
+	b := img.Bounds()
+	indexes := map[color.NRGBA]uint8{}
+	var palette color.Palette
+	paletted := image.NewPaletted(b, nil)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A == 0 {
+				// transparent pixels are the same whatever their color is
+				c = color.NRGBA{}
+			}
+			i, ok := indexes[c]
+			if !ok {
+				if len(palette) == maxPaletteSize {
+					return nil
+				}
+				i = uint8(len(palette))
+				indexes[c] = i
+				palette = append(palette, c)
+			}
+			paletted.Pix[paletted.PixOffset(x, y)] = i
+		}
+	}
+	paletted.Palette = palette
+	return paletted
+}
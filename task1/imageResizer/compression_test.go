@@ -0,0 +1,244 @@
+package imageResizer
+
+import (
+	"bytes"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestEncodeProgressiveJPEG(t *testing.T) {
+	medium, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer medium.Close()
+	transparent, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transparent.Close()
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"photo", resize.Resize(333, 0, medium.originalImg, resize.Bilinear)},
+		{"with alpha", resize.Resize(200, 0, transparent.originalImg, resize.Bilinear)},
+		{"one pixel", uniform(1, 1, color.RGBA{200, 100, 50, 255})},
+		{"odd size", uniform(17, 9, color.RGBA{10, 200, 30, 255})},
+		{"gray", image.NewGray(image.Rect(0, 0, 20, 20))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var progressive, baseline bytes.Buffer
+			if err := encodeProgressiveJPEG(&progressive, tt.img, 80); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(progressive.Bytes(), []byte{0xFF, 0xC2}) {
+				t.Error("Got no SOF2 marker of progressive JPEG")
+			}
+			got, err := jpeg.Decode(&progressive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// loses no more than image saved by image/jpeg, encoders round
+			// coefficients a bit differently so pixels themselves differ
+			jpeg.Encode(&baseline, tt.img, &jpeg.Options{Quality: 80})
+			want, _ := jpeg.Decode(&baseline)
+			if got, want := meanError(got, tt.img), meanError(want, tt.img); got > want*1.1+0.5 {
+				t.Errorf("Got mean error %.2f, but expected at most %.2f", got, want)
+			}
+		})
+	}
+}
+
+func TestEncodeJPEGWithin(t *testing.T) {
+	ir, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	img := resize.Resize(400, 0, ir.originalImg, resize.Bilinear)
+	size := func(quality int, progressive bool) int64 {
+		var buf bytes.Buffer
+		if err := encodeJPEG(&buf, img, quality, progressive); err != nil {
+			t.Fatal(err)
+		}
+		return int64(buf.Len())
+	}
+
+	tests := []struct {
+		name   string
+		preset Preset
+		want   int64
+	}{
+		{"fits", Preset{MaxSize: size(defaultQuality, false)}, size(defaultQuality, false)},
+		{"lower quality", Preset{Quality: 80, MaxSize: size(50, false)}, size(50, false)},
+		{"progressive", Preset{MaxSize: size(50, true), Progressive: true}, size(50, true)},
+		{"lowest quality", Preset{MaxSize: size(1, false)}, size(1, false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := encodeJPEGWithin(&buf, img, tt.preset); err != nil {
+				t.Fatal(err)
+			}
+			if got := int64(buf.Len()); got != tt.want {
+				t.Errorf("Got %v bytes, but expected %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("too small", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := encodeJPEGWithin(&buf, img, Preset{Name: "tiny", MaxSize: 100})
+		maxSize, ok := err.(*MaxSizeError)
+		if !ok {
+			t.Fatalf("Got %v, but expected MaxSizeError", err)
+		}
+		if maxSize.Size != size(1, false) {
+			t.Errorf("Got %v bytes, but expected %v", maxSize.Size, size(1, false))
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Got %v bytes written, but expected none", buf.Len())
+		}
+	})
+}
+
+func TestImageResizer_SavePresets_maxSize(t *testing.T) {
+	useTestService(t)
+	ir, err := imageResizerFromImagePath("testdata/BigImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	result, err := ir.SavePresets([]Preset{
+		{Name: "small", Width: 400, Height: 400, Format: JPEG, MaxSize: 20000, Progressive: true},
+		// JPEG upload is saved as JPEG
+		{Name: "original format", Width: 400, Height: 400, MaxSize: 20000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range result.Variants[1:] {
+		if v.Size > 20000 || v.Size == 0 {
+			t.Errorf("%s: got %v bytes, but expected at most %v", v.Preset, v.Size, 20000)
+		}
+	}
+
+	// budget doesn't apply to PNG upload saved as PNG
+	png, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer png.Close()
+	result, err = png.SavePresets([]Preset{{Name: "original format", Width: 400, Height: 400, MaxSize: 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := result.Variants[1]; v.Format != PNG || v.Size <= 100 {
+		t.Errorf("Got %v image of %v bytes, but expected PNG image without limit", v.Format, v.Size)
+	}
+}
+
+func TestReducePalette(t *testing.T) {
+	flag := uniform(300, 200, color.RGBA{0, 0, 255, 255})
+	draw.Draw(flag, image.Rect(0, 100, 300, 200), image.NewUniform(color.RGBA{255, 255, 0, 255}), image.Point{}, draw.Src)
+	flag.Set(0, 0, color.Transparent)
+	flag.Set(1, 0, color.RGBA{}) // transparent black is the same color
+
+	gradient := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			gradient.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 0, 255})
+		}
+	}
+
+	tests := []struct {
+		name   string
+		img    image.Image
+		colors int
+	}{
+		{"few colors", flag, 3},
+		{"too many colors", gradient, 0},
+		{"paletted", image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black}), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paletted := reducePalette(tt.img)
+			if tt.colors == 0 {
+				if paletted != nil {
+					t.Errorf("Got %v colors, but expected no palette", len(paletted.Palette))
+				}
+				return
+			}
+			if paletted == nil || len(paletted.Palette) != tt.colors {
+				t.Fatalf("Got %v, but expected %v colors", paletted, tt.colors)
+			}
+
+			// lossless and smaller
+			var reduced, full bytes.Buffer
+			png.Encode(&reduced, paletted)
+			png.Encode(&full, tt.img)
+			if reduced.Len() >= full.Len() {
+				t.Errorf("Got %v bytes, but expected less than %v", reduced.Len(), full.Len())
+			}
+			got, err := png.Decode(&reduced)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := tt.img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					r1, g1, b1, a1 := got.At(x, y).RGBA()
+					r2, g2, b2, a2 := tt.img.At(x, y).RGBA()
+					if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+						t.Fatalf("Got %v at %v,%v, but expected %v", got.At(x, y), x, y, tt.img.At(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeImage_optimize(t *testing.T) {
+	flag := uniform(300, 200, color.RGBA{0, 0, 255, 255})
+	draw.Draw(flag, image.Rect(0, 100, 300, 200), image.NewUniform(color.RGBA{255, 255, 0, 255}), image.Point{}, draw.Src)
+
+	for _, optimize := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, flag, Preset{Format: PNG, Optimize: optimize}); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, paletted := img.(*image.Paletted); paletted != optimize {
+			t.Errorf("optimize %v: got paletted %v, but expected %v", optimize, paletted, optimize)
+		}
+	}
+}
+
+// Returns mean difference of channels of the images, images have the same size.
+func meanError(got, want image.Image) float64 {
+	gb, wb := got.Bounds(), want.Bounds()
+	sum := 0.0
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			r1, g1, b1, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r2, g2, b2, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			sum += math.Abs(float64(r1>>8)-float64(r2>>8)) +
+				math.Abs(float64(g1>>8)-float64(g2>>8)) +
+				math.Abs(float64(b1>>8)-float64(b2>>8))
+		}
+	}
+	return sum / float64(3*gb.Dx()*gb.Dy())
+}
@@ -0,0 +1,98 @@
+package imageResizer
+
+import (
+	"context"
+	"sync"
+)
+
+// Sets memory budget of concurrent decodes in megabytes.
+func (s *Service) SetMemoryBudget(budgetMB int64) {
+	s.decodeBudget = newMemorySemaphore(budgetMB * 1024 * 1024)
+}
+
+// memorySemaphore limits total size of memory acquired by goroutines.
+type memorySemaphore struct {
+	mu    sync.Mutex
+	size  int64
+	taken int64
+	// closed and replaced on every release, so waiters check again
+	released chan struct{}
+}
+
+func newMemorySemaphore(size int64) *memorySemaphore {
+	return &memorySemaphore{size: size, released: make(chan struct{})}
+}
+
+// Blocks until n bytes are available and takes them. Returns number of
+// bytes actually taken, it has to be passed to release. Requests larger
+// than the budget take the whole budget, so they run alone. Nothing is
+// taken if ctx is done before, error of ctx is returned then.
+func (s *memorySemaphore) acquire(ctx context.Context, n int64) (int64, error) {
+	if n > s.size {
+		n = s.size
+	}
+	s.mu.Lock()
+	for s.taken+n > s.size {
+		released := s.released
+		s.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		s.mu.Lock()
+	}
+	s.taken += n
+	s.mu.Unlock()
+	return n, nil
+}
+
+func (s *memorySemaphore) release(n int64) {
+	s.mu.Lock()
+	s.taken -= n
+	close(s.released)
+	s.released = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// keyedMutex locks by key, e.g. ID of image. Zero value is ready to use.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// number of goroutines holding or waiting for the lock
+	users int
+}
+
+// Locks key and returns function which unlocks it.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedLock{}
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.users++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.users--; l.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Returns approximate memory taken by decoded image, 4 bytes per pixel.
+func decodedSize(width, height, frames int) int64 {
+	return int64(width) * int64(height) * int64(frames) * 4
+}
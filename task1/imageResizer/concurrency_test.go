@@ -0,0 +1,217 @@
+package imageResizer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemorySemaphore(t *testing.T) {
+	s := newMemorySemaphore(100)
+
+	var wg sync.WaitGroup
+	var inUse, maxInUse int64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := s.acquire(context.Background(), 40)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer s.release(n)
+
+			used := atomic.AddInt64(&inUse, n)
+			for {
+				max := atomic.LoadInt64(&maxInUse)
+				if used <= max || atomic.CompareAndSwapInt64(&maxInUse, max, used) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(&inUse, -n)
+		}()
+	}
+	wg.Wait()
+
+	if maxInUse > 100 {
+		t.Errorf("Got %v, but expected at most %v", maxInUse, 100)
+	}
+
+	// larger than budget is clamped, so it doesn't block forever
+	if n, _ := s.acquire(context.Background(), 1000); n != 100 {
+		t.Errorf("Got %v, but expected %v", n, 100)
+	}
+	s.release(100)
+}
+
+func TestMemorySemaphore_canceled(t *testing.T) {
+	s := newMemorySemaphore(100)
+	n, _ := s.acquire(context.Background(), 60)
+
+	// waiting stops when request is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.acquire(ctx, 60); err != context.DeadlineExceeded {
+		t.Errorf("Got %v, but expected %v", err, context.DeadlineExceeded)
+	}
+	if s.taken != 60 {
+		t.Errorf("Got %v, but expected %v", s.taken, 60)
+	}
+
+	// waiter gets the budget when it's released
+	done := make(chan int64)
+	go func() {
+		n, _ := s.acquire(context.Background(), 60)
+		done <- n
+	}()
+	s.release(n)
+	select {
+	case n := <-done:
+		s.release(n)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiter is not woken up by release")
+	}
+	if s.taken != 0 {
+		t.Errorf("Got %v, but expected %v", s.taken, 0)
+	}
+}
+
+func TestImageResizer_Close(t *testing.T) {
+	s := useTestService(t)
+
+	ir, err := imageResizerFromImagePath("testdata/JPEGImage.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// budget is held while image is rendered
+	if _, err := ir.GetVariant(ThumbnailPreset); err != nil {
+		t.Fatal(err)
+	}
+	if s.decodeBudget.taken == 0 {
+		t.Errorf("Got no memory taken, but expected memory of decoded image")
+	}
+	ir.Close()
+	ir.Close()
+	if s.decodeBudget.taken != 0 {
+		t.Errorf("Got %v, but expected %v", s.decodeBudget.taken, 0)
+	}
+
+	// budget of image which failed to decode is released at once
+	data := readTestFile(t, "testdata/JPEGImage.jpeg")
+	if _, err := NewImageResizer(bytes.NewReader(data[:len(data)/2]), "broken.jpeg", 0); err == nil {
+		t.Fatal("Got no error, but expected error of truncated image")
+	}
+	if s.decodeBudget.taken != 0 {
+		t.Errorf("Got %v, but expected %v", s.decodeBudget.taken, 0)
+	}
+
+	// upload of canceled request doesn't wait for the budget
+	n, _ := s.decodeBudget.acquire(context.Background(), s.decodeBudget.size)
+	defer s.decodeBudget.release(n)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.newImageResizer(ctx, bytes.NewReader(data), "image.jpeg", 0); err != context.Canceled {
+		t.Errorf("Got %v, but expected %v", err, context.Canceled)
+	}
+}
+
+func TestImageResizer_SavePresets_parallel(t *testing.T) {
+	useTestService(t)
+
+	ir, err := imageResizerFromImagePath("testdata/JPEGImage.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	presets := []Preset{
+		{Name: "a", Width: 100, Height: 100},
+		{Name: "b", Width: 200, Height: 100},
+		{Name: "c", Width: 300, Height: 100},
+		{Name: "d", Width: 400, Height: 100},
+	}
+	result, err := ir.SavePresets(presets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// variants keep order of presets
+	if len(result.Variants) != len(presets)+1 {
+		t.Fatalf("Got %v variants, but expected %v", len(result.Variants), len(presets)+1)
+	}
+	for i, p := range presets {
+		v := result.Variants[i+1]
+		if v.Preset != p.Name || v.Width != int(p.Width) {
+			t.Errorf("Got %+v, but expected variant of %+v", v, p)
+		}
+	}
+}
+
+func TestImageResizer_SavePresets_concurrent(t *testing.T) {
+	s := useTestService(t)
+	data := readTestFile(t, "testdata/JPEGImage.jpeg")
+
+	// the same image is uploaded at once with other presets
+	names := []string{"a", "b", "c", "d"}
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(name string, width uint) {
+			defer wg.Done()
+			ir, err := NewImageResizer(bytes.NewReader(data), "image.jpeg", 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer ir.Close()
+			if _, err := ir.SavePresets([]Preset{{Name: name, Width: width, Height: 100}}); err != nil {
+				t.Error(err)
+			}
+		}(name, uint(100+i))
+	}
+	wg.Wait()
+
+	ir, err := NewImageResizer(bytes.NewReader(data), "image.jpeg", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	meta, err := s.index.Get(ir.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if meta.variant(name).Preset == "" {
+			t.Errorf("Got no variant of %s among %+v", name, meta.Variants)
+		}
+	}
+	if len(s.imageLocks.locks) != 0 {
+		t.Errorf("Got %v locks, but expected none", len(s.imageLocks.locks))
+	}
+}
+
+func TestImageResizer_SavePresets_deleted(t *testing.T) {
+	s := useTestService(t)
+	saved := saveOriginal(t, "testdata/PNGImage.png", "image.png")
+
+	// job loads image which is deleted before presets are saved
+	ir, err := s.LoadImageResizer(saved.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if err := s.DeleteImage(saved.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ir.SavePresets([]Preset{ThumbnailPreset}); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+	if _, err := s.index.Get(saved.ID()); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected deleted image to stay deleted", err)
+	}
+}
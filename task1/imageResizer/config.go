@@ -0,0 +1,329 @@
+package imageResizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is settings of the server and its Service. It's read from
+// JSON file, environment variables override settings of the file.
+type Config struct {
+	// Port HTTP server listens on, env PORT
+	Port string `json:"port"`
+	// Timeouts of HTTP server, env READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT
+	ReadTimeout  Duration `json:"readTimeout"`
+	WriteTimeout Duration `json:"writeTimeout"`
+	IdleTimeout  Duration `json:"idleTimeout"`
+	// How long in-flight requests and jobs are waited for
+	// on shutdown, env SHUTDOWN_TIMEOUT
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+
+	Storage StorageConfig `json:"storage"`
+	// File of the index, it isn't persisted if empty, env INDEX_FILE
+	IndexFile string `json:"indexFile"`
+	// Presets rendered for uploaded images, they are read
+	// from PresetsFile if empty, env PRESETS_FILE. Built-in presets
+	// are used if default presets.json isn't found.
+	Presets     []Preset `json:"presets,omitempty"`
+	PresetsFile string   `json:"presetsFile"`
+	// Formats images are saved in by format of uploaded image,
+	// env OUTPUT_FORMATS as "webp=png,bmp=jpeg"
+	OutputFormats map[string]string `json:"outputFormats,omitempty"`
+
+	// Maximum image size in megabytes, env MAX_IMAGE_SIZE
+	MaxImageSize int64 `json:"maxImageSize"`
+	// Maximum number of pixels of decoded image, env MAX_IMAGE_PIXELS
+	MaxImagePixels int64 `json:"maxImagePixels"`
+	// Memory decoded images may take at once in megabytes, env MEMORY_BUDGET
+	MemoryBudget int64 `json:"memoryBudget"`
+	// Maximum size of batch request in megabytes, env MAX_BATCH_SIZE
+	MaxBatchSize int64 `json:"maxBatchSize"`
+	// Maximum number of images in batch request, env MAX_BATCH_FILES
+	MaxBatchFiles int `json:"maxBatchFiles"`
+
+	// Strip Exif metadata from saved original image, env STRIP_METADATA
+	StripMetadata bool `json:"stripMetadata"`
+	// Watermark, env WATERMARK_IMAGE, WATERMARK_TEXT, WATERMARK_POSITION,
+	// WATERMARK_OPACITY, WATERMARK_SCALE and WATERMARK_ORIGINAL
+	Watermark *Watermark `json:"watermark,omitempty"`
+
+	// Directory of asynchronous jobs, asynchronous processing is
+	// disabled if empty, env JOBS_DIR
+	JobsDir string `json:"jobsDir"`
+	// Number of jobs processed at once, env JOB_WORKERS
+	JobWorkers int `json:"jobWorkers"`
+	// How long finished jobs are kept, they are kept forever if zero,
+	// env JOB_RETENTION
+	JobRetention Duration `json:"jobRetention"`
+
+	// Key URLs are signed with, URLs aren't signed if empty, env SIGNING_KEY
+	SigningKey string `json:"signingKey,omitempty"`
+	// URL of the server signed URLs point to, "http://localhost:port"
+	// if empty, env PUBLIC_URL
+	PublicURL string `json:"publicURL,omitempty"`
+	// How long signed URLs are valid, env URL_TTL
+	URLTTL Duration `json:"urlTTL"`
+
+	// Directory where images rendered on the fly are cached, env CACHE_DIR
+	CacheDir string `json:"cacheDir"`
+	// Maximum size of cached images in megabytes, least recently used
+	// are removed when it's exceeded, no limit if zero, env CACHE_SIZE
+	CacheSize int64 `json:"cacheSize"`
+	// Maximum width and height of image rendered on the fly, env DYNAMIC_MAX_SIZE
+	DynamicMaxSize uint `json:"dynamicMaxSize"`
+	// Allowed widths and heights of image rendered on the fly, any size
+	// is allowed if empty, env DYNAMIC_SIZES as "100,200,400"
+	DynamicSizes []uint `json:"dynamicSizes,omitempty"`
+
+	// URL of OTLP/HTTP collector spans are sent to, e.g.
+	// "http://localhost:4318/v1/traces", spans aren't exported
+	// if empty, env TRACE_ENDPOINT
+	TraceEndpoint string `json:"traceEndpoint,omitempty"`
+}
+
+// StorageConfig describes where images are saved.
+type StorageConfig struct {
+	// "local" or "s3", env STORAGE
+	Type string `json:"type"`
+	// Directory of local storage, env STORAGE_ROOT
+	Root string `json:"root"`
+	// URL local storage is served at, "http://localhost:port/files"
+	// if empty, env STORAGE_BASE_URL
+	BaseURL string `json:"baseURL,omitempty"`
+	// S3 compatible storage, env S3_ENDPOINT, S3_REGION, S3_BUCKET,
+	// S3_ACCESS_KEY and S3_SECRET_KEY
+	S3Endpoint  string `json:"s3Endpoint,omitempty"`
+	S3Region    string `json:"s3Region,omitempty"`
+	S3Bucket    string `json:"s3Bucket,omitempty"`
+	S3AccessKey string `json:"s3AccessKey,omitempty"`
+	S3SecretKey string `json:"s3SecretKey,omitempty"`
+}
+
+// Duration is time.Duration written in JSON as string, e.g. "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// File presets are read from by default, it's relative to working
+// directory, so it's optional.
+const defaultPresetsFile = "presets.json"
+
+// Returns config with default settings.
+func DefaultConfig() Config {
+	return Config{
+		Port:            "8080",
+		ReadTimeout:     Duration(time.Minute),
+		WriteTimeout:    Duration(2 * time.Minute),
+		IdleTimeout:     Duration(2 * time.Minute),
+		ShutdownTimeout: Duration(30 * time.Second),
+		Storage: StorageConfig{
+			Type: "local",
+			Root: "resizedImages",
+		},
+		IndexFile:      "index.json",
+		PresetsFile:    defaultPresetsFile,
+		MaxImageSize:   5,
+		MaxImagePixels: 50 * 1000 * 1000,
+		MemoryBudget:   512,
+		MaxBatchSize:   100,
+		MaxBatchFiles:  50,
+		StripMetadata:  true,
+		JobsDir:        "jobs",
+		JobWorkers:     runtime.NumCPU(),
+		JobRetention:   Duration(24 * time.Hour),
+		URLTTL:         Duration(24 * time.Hour),
+		CacheDir:       "cache",
+		CacheSize:      512,
+		DynamicMaxSize: 2000,
+	}
+}
+
+// Returns default config overridden by JSON file at path, unless path
+// is empty, and then by environment variables.
+func LoadConfig(path string) (Config, error) {
+	c := DefaultConfig()
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return Config{}, err
+		}
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		// misspelled setting would be silently ignored otherwise
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c); err != nil {
+			return Config{}, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Overrides settings by environment variables which are set.
+func (c *Config) loadEnv() error {
+	if c.Watermark == nil && (os.Getenv("WATERMARK_IMAGE") != "" || os.Getenv("WATERMARK_TEXT") != "") {
+		c.Watermark = &Watermark{}
+	}
+
+	vars := []envVar{
+		{"PORT", setString(&c.Port)},
+		{"READ_TIMEOUT", setDuration(&c.ReadTimeout)},
+		{"WRITE_TIMEOUT", setDuration(&c.WriteTimeout)},
+		{"IDLE_TIMEOUT", setDuration(&c.IdleTimeout)},
+		{"SHUTDOWN_TIMEOUT", setDuration(&c.ShutdownTimeout)},
+		{"STORAGE", setString(&c.Storage.Type)},
+		{"STORAGE_ROOT", setString(&c.Storage.Root)},
+		{"STORAGE_BASE_URL", setString(&c.Storage.BaseURL)},
+		{"S3_ENDPOINT", setString(&c.Storage.S3Endpoint)},
+		{"S3_REGION", setString(&c.Storage.S3Region)},
+		{"S3_BUCKET", setString(&c.Storage.S3Bucket)},
+		{"S3_ACCESS_KEY", setString(&c.Storage.S3AccessKey)},
+		{"S3_SECRET_KEY", setString(&c.Storage.S3SecretKey)},
+		{"INDEX_FILE", setString(&c.IndexFile)},
+		{"PRESETS_FILE", func(value string) error {
+			// presets of config file are replaced too
+			c.Presets = nil
+			c.PresetsFile = value
+			return nil
+		}},
+		{"OUTPUT_FORMATS", func(value string) error {
+			if value == "" {
+				c.OutputFormats = nil
+				return nil
+			}
+			formats := map[string]string{}
+			for _, pair := range strings.Split(value, ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 {
+					return fmt.Errorf("invalid pair %q", pair)
+				}
+				formats[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+			}
+			c.OutputFormats = formats
+			return nil
+		}},
+		{"MAX_IMAGE_SIZE", setInt64(&c.MaxImageSize)},
+		{"MAX_IMAGE_PIXELS", setInt64(&c.MaxImagePixels)},
+		{"MEMORY_BUDGET", setInt64(&c.MemoryBudget)},
+		{"MAX_BATCH_SIZE", setInt64(&c.MaxBatchSize)},
+		{"MAX_BATCH_FILES", setInt(&c.MaxBatchFiles)},
+		{"STRIP_METADATA", setBool(&c.StripMetadata)},
+		{"JOBS_DIR", setString(&c.JobsDir)},
+		{"JOB_WORKERS", setInt(&c.JobWorkers)},
+		{"JOB_RETENTION", setDuration(&c.JobRetention)},
+		{"SIGNING_KEY", setString(&c.SigningKey)},
+		{"PUBLIC_URL", setString(&c.PublicURL)},
+		{"URL_TTL", setDuration(&c.URLTTL)},
+		{"CACHE_DIR", setString(&c.CacheDir)},
+		{"CACHE_SIZE", setInt64(&c.CacheSize)},
+		{"DYNAMIC_MAX_SIZE", func(value string) error {
+			size, err := strconv.ParseUint(value, 10, 32)
+			c.DynamicMaxSize = uint(size)
+			return err
+		}},
+		{"DYNAMIC_SIZES", func(value string) error {
+			c.DynamicSizes = nil
+			for _, size := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' }) {
+				allowed, err := strconv.ParseUint(strings.TrimSpace(size), 10, 32)
+				if err != nil {
+					return err
+				}
+				c.DynamicSizes = append(c.DynamicSizes, uint(allowed))
+			}
+			return nil
+		}},
+		{"TRACE_ENDPOINT", setString(&c.TraceEndpoint)},
+	}
+	if w := c.Watermark; w != nil {
+		vars = append(vars, []envVar{
+			{"WATERMARK_IMAGE", setString(&w.Image)},
+			{"WATERMARK_TEXT", setString(&w.Text)},
+			{"WATERMARK_POSITION", setString(&w.Position)},
+			{"WATERMARK_OPACITY", setFloat(&w.Opacity)},
+			{"WATERMARK_SCALE", setFloat(&w.Scale)},
+			{"WATERMARK_ORIGINAL", setBool(&w.Original)},
+		}...)
+	}
+
+	for _, v := range vars {
+		value, ok := os.LookupEnv(v.key)
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("%s: %v", v.key, err)
+		}
+	}
+	return nil
+}
+
+// envVar is environment variable which overrides setting.
+type envVar struct {
+	key string
+	set func(value string) error
+}
+
+func setString(p *string) func(string) error {
+	return func(value string) error {
+		*p = value
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(value string) (err error) {
+		*p, err = strconv.Atoi(value)
+		return err
+	}
+}
+
+func setInt64(p *int64) func(string) error {
+	return func(value string) (err error) {
+		*p, err = strconv.ParseInt(value, 10, 64)
+		return err
+	}
+}
+
+func setFloat(p *float64) func(string) error {
+	return func(value string) (err error) {
+		*p, err = strconv.ParseFloat(value, 64)
+		return err
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(value string) (err error) {
+		*p, err = strconv.ParseBool(value)
+		return err
+	}
+}
+
+func setDuration(p *Duration) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		*p = Duration(d)
+		return err
+	}
+}
@@ -0,0 +1,78 @@
+package imageResizer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(path, []byte(`{
+		"port": "9000",
+		"writeTimeout": "5m",
+		"storage": {"type": "s3", "s3Bucket": "images"},
+		"presets": [{"name": "small", "width": 100, "height": 100}],
+		"maxImageSize": 10,
+		"watermark": {"text": "example.com"}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PORT", "9090")
+	t.Setenv("MAX_IMAGE_PIXELS", "1000")
+	t.Setenv("DYNAMIC_SIZES", "100, 200")
+	t.Setenv("OUTPUT_FORMATS", "webp=png,bmp=jpeg")
+	t.Setenv("WATERMARK_OPACITY", "0.8")
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.Port = "9090"
+	want.WriteTimeout = Duration(5 * time.Minute)
+	want.Storage = StorageConfig{Type: "s3", Root: "resizedImages", S3Bucket: "images"}
+	want.Presets = []Preset{{Name: "small", Width: 100, Height: 100}}
+	want.MaxImageSize = 10
+	want.MaxImagePixels = 1000
+	want.DynamicSizes = []uint{100, 200}
+	want.OutputFormats = map[string]string{WEBP: PNG, BMP: JPEG}
+	want.Watermark = &Watermark{Text: "example.com", Opacity: 0.8}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Got %+v, but expected %+v", c, want)
+	}
+}
+
+func TestLoadConfig_invalid(t *testing.T) {
+	dir := t.TempDir()
+	misspelled := filepath.Join(dir, "misspelled.json")
+	ioutil.WriteFile(misspelled, []byte(`{"maxImageSise": 10}`), 0644)
+	duration := filepath.Join(dir, "duration.json")
+	ioutil.WriteFile(duration, []byte(`{"readTimeout": 30}`), 0644)
+
+	tests := []struct {
+		name string
+		path string
+		env  map[string]string
+	}{
+		{"missing file", filepath.Join(dir, "missing.json"), nil},
+		{"unknown setting", misspelled, nil},
+		{"duration", duration, nil},
+		{"env number", "", map[string]string{"MAX_IMAGE_SIZE": "big"}},
+		{"env duration", "", map[string]string{"URL_TTL": "1 day"}},
+		{"env output formats", "", map[string]string{"OUTPUT_FORMATS": "webp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if _, err := LoadConfig(tt.path); err == nil {
+				t.Error("Got no error, but expected one")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package imageResizer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Returns the biggest rectangle of preset's aspect ratio inside of image
+// positioned according to the crop mode of preset.
+func cropRect(img image.Image, p Preset) image.Rectangle {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	cw, ch := w, int(int64(w)*int64(p.Height)/int64(p.Width))
+	if ch > h {
+		cw, ch = int(int64(h)*int64(p.Width)/int64(p.Height)), h
+	}
+	if cw < 1 {
+		cw = 1
+	}
+	if ch < 1 {
+		ch = 1
+	}
+
+	var x, y int
+	switch p.Crop {
+	case CropTop:
+		x, y = (w-cw)/2, 0
+	case CropFocal:
+		fx, fy := p.focus()
+		x = clamp(int(fx*float64(w))-cw/2, 0, w-cw)
+		y = clamp(int(fy*float64(h))-ch/2, 0, h-ch)
+	case CropEntropy:
+		x, y = entropyOffset(img, cw, ch)
+	default:
+		x, y = (w-cw)/2, (h-ch)/2
+	}
+
+	return image.Rect(x, y, x+cw, y+ch).Add(b.Min)
+}
+
+// Returns offset of cw x ch window with the most detailed content,
+// measured by entropy of its grayscale histogram.
+func entropyOffset(img image.Image, cw, ch int) (int, int) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if cw >= w && ch >= h {
+		return 0, 0
+	}
+
+	// entropy is measured on small copy of image
+	small := resize.Thumbnail(128, 128, img, resize.Bilinear)
+	gray := image.NewGray(image.Rect(0, 0, small.Bounds().Dx(), small.Bounds().Dy()))
+	draw.Draw(gray, gray.Bounds(), small, small.Bounds().Min, draw.Src)
+	scale := float64(gray.Rect.Dx()) / float64(w)
+
+	window := image.Rect(0, 0, max1(int(float64(cw)*scale)), max1(int(float64(ch)*scale)))
+	// window slides along one axis only, the other one is fully covered
+	step := image.Pt(1, 0)
+	steps := gray.Rect.Dx() - window.Dx()
+	if ch < h {
+		step = image.Pt(0, 1)
+		steps = gray.Rect.Dy() - window.Dy()
+	}
+
+	best, bestOffset := -1.0, image.Point{}
+	for i := 0; i <= steps; i++ {
+		offset := step.Mul(i)
+		if e := entropy(gray, window.Add(offset)); e > best {
+			best, bestOffset = e, offset
+		}
+	}
+
+	x := clamp(int(float64(bestOffset.X)/scale), 0, w-cw)
+	y := clamp(int(float64(bestOffset.Y)/scale), 0, h-ch)
+	return x, y
+}
+
+// Returns Shannon entropy of gray levels inside of r.
+func entropy(img *image.Gray, r image.Rectangle) float64 {
+	var histogram [256]int
+	r = r.Intersect(img.Rect)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for _, v := range img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)] {
+			histogram[v]++
+		}
+	}
+
+	total := float64(r.Dx() * r.Dy())
+	e := 0.0
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / total
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// Returns part of image inside of r.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	cropped := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, r.Min, draw.Src)
+	return cropped
+}
+
+// Returns focal point of the preset as fractions of image width and height,
+// center of image if it isn't set.
+func (p Preset) focus() (float64, float64) {
+	x, y, err := parseFocus(p.Focus)
+	if err != nil {
+		return 0.5, 0.5
+	}
+	return x, y
+}
+
+// Parses focal point of the form "x,y" where x and y are from 0 to 1.
+func parseFocus(s string) (float64, float64, error) {
+	if s == "" {
+		return 0.5, 0.5, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid focus %q!!!", s)
+	}
+	x, errX := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	y, errY := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errX != nil || errY != nil || x < 0 || x > 1 || y < 0 || y > 1 {
+		return 0, 0, fmt.Errorf("Invalid focus %q!!!", s)
+	}
+	return x, y, nil
+}
+
+// Parses background color: "transparent", "#rgb", "#rrggbb" or "#rrggbbaa".
+// White is returned for empty string.
+func parseColor(s string) (color.Color, error) {
+	switch s {
+	case "":
+		return color.White, nil
+	case "transparent":
+		return color.Transparent, nil
+	}
+
+	hexColor := strings.TrimPrefix(s, "#")
+	if len(hexColor) == 3 {
+		hexColor = string([]byte{hexColor[0], hexColor[0], hexColor[1], hexColor[1], hexColor[2], hexColor[2]})
+	}
+	if len(hexColor) == 6 {
+		hexColor += "ff"
+	}
+	rgba, err := hex.DecodeString(hexColor)
+	if err != nil || len(rgba) != 4 || !strings.HasPrefix(s, "#") {
+		return nil, fmt.Errorf("Invalid color %q!!!", s)
+	}
+	return color.NRGBA{R: rgba[0], G: rgba[1], B: rgba[2], A: rgba[3]}, nil
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+func max1(v int) int {
+	if v < 1 {
+		return 1
+	}
+	return v
+}
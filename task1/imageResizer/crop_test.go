@@ -0,0 +1,136 @@
+package imageResizer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestCropRect(t *testing.T) {
+	wide := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	tall := image.NewRGBA(image.Rect(0, 0, 100, 200))
+
+	// right third of the image is noise, the rest is plain
+	detailed := image.NewGray(image.Rect(0, 0, 300, 100))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < 100; y++ {
+		for x := 200; x < 300; x++ {
+			detailed.SetGray(x, y, color.Gray{Y: uint8(rnd.Intn(256))})
+		}
+	}
+
+	tests := []struct {
+		name  string
+		img   image.Image
+		crop  string
+		focus string
+		want  image.Rectangle
+		// allowed shift of entropy crop, it's found on scaled down image
+		tolerance int
+	}{
+		{"center wide", wide, CropCenter, "", image.Rect(50, 0, 150, 100), 0},
+		{"center tall", tall, CropCenter, "", image.Rect(0, 50, 100, 150), 0},
+		{"top wide", wide, CropTop, "", image.Rect(50, 0, 150, 100), 0},
+		{"top tall", tall, CropTop, "", image.Rect(0, 0, 100, 100), 0},
+		{"focal left", wide, CropFocal, "0,0.5", image.Rect(0, 0, 100, 100), 0},
+		{"focal right", wide, CropFocal, "0.9,0.5", image.Rect(100, 0, 200, 100), 0},
+		{"focal bottom", tall, CropFocal, "0.5,0.7", image.Rect(0, 90, 100, 190), 0},
+		{"entropy", detailed, CropEntropy, "", image.Rect(200, 0, 300, 100), 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Preset{Name: "square", Width: 100, Height: 100, Crop: tt.crop, Focus: tt.focus}
+			got := cropRect(tt.img, p)
+			if d := got.Min.Sub(tt.want.Min); got.Size() != tt.want.Size() || abs(d.X) > tt.tolerance || abs(d.Y) > tt.tolerance {
+				t.Errorf("Got %v, but expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeImage_center(t *testing.T) {
+	// red bands on the sides are cut off, only green middle is left
+	img := uniform(300, 100, color.RGBA{255, 0, 0, 255})
+	draw.Draw(img, image.Rect(75, 0, 225, 100), image.NewUniform(color.RGBA{0, 255, 0, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		width, height uint
+	}{
+		{800, 800},
+		{7, 5},
+		{2, 3},
+		{3, 2},
+		{1, 1},
+	}
+	for _, tt := range tests {
+		p := Preset{Name: "center", Width: tt.width, Height: tt.height, Crop: CropCenter}
+		resized, err := resizeImage(context.Background(), img, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := resized.Bounds().Size(); size != image.Pt(int(tt.width), int(tt.height)) {
+			t.Errorf("Got %v, but expected %vx%v", size, tt.width, tt.height)
+			continue
+		}
+		b := resized.Bounds()
+		for _, x := range []int{b.Min.X, b.Max.X - 1} {
+			if r, _, _, _ := resized.At(x, b.Min.Y).RGBA(); r>>8 > 16 {
+				t.Errorf("%vx%v: got %v at %v, but expected green", tt.width, tt.height, resized.At(x, b.Min.Y), x)
+			}
+		}
+	}
+}
+
+func TestResizeImage_letterbox(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	p := Preset{Name: "box", Width: 100, Height: 100, Crop: CropContain, Background: "#ff0000"}
+
+	resized, err := resizeImage(context.Background(), img, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := resized.Bounds().Size(); size != image.Pt(100, 100) {
+		t.Fatalf("Got %v, but expected 100x100", size)
+	}
+	if got := color.RGBAModel.Convert(resized.At(0, 0)); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("Got %v background, but expected red", got)
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    color.Color
+		wantErr bool
+	}{
+		{"", color.White, false},
+		{"transparent", color.Transparent, false},
+		{"#f00", color.NRGBA{255, 0, 0, 255}, false},
+		{"#00ff00", color.NRGBA{0, 255, 0, 255}, false},
+		{"#0000ff80", color.NRGBA{0, 0, 255, 128}, false},
+		{"00ff00", nil, true},
+		{"#gg0000", nil, true},
+		{"red", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseColor(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, but expected error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Got %v, but expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
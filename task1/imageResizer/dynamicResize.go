@@ -0,0 +1,173 @@
+package imageResizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fit modes of images rendered on the fly
+var fitModes = map[string]string{
+	"cover":   CropCenter,
+	"contain": CropContain,
+	"fill":    CropFill,
+	"inside":  CropNone,
+}
+
+// ErrDynamicSize is returned for sizes which are not allowed to be rendered on the fly.
+var ErrDynamicSize = errors.New("Image size not allowed!!!")
+
+// Sets directory where images rendered on the fly are cached.
+func (s *Service) SetCacheDir(dir string) {
+	s.cacheDir = dir
+}
+
+// Limits size of cached images in megabytes, there is no limit if zero.
+func (s *Service) SetCacheSize(sizeMB int64) {
+	s.maxCacheSize = sizeMB * 1024 * 1024
+}
+
+// Limits sizes of images rendered on the fly by maximum size of each side
+// and, if allowed is not empty, by the list of allowed sizes.
+func (s *Service) SetDynamicSizeLimits(max uint, allowed []uint) {
+	s.maxDynamicSize = max
+	s.allowedDynamicSizes = allowed
+}
+
+// Returns preset for rendering image on the fly.
+func (s *Service) dynamicPreset(width, height uint, fit string) (Preset, error) {
+	if fit == "" {
+		fit = "cover"
+	}
+	crop, ok := fitModes[fit]
+	if !ok {
+		return Preset{}, fmt.Errorf("Unknown fit mode %q!!!", fit)
+	}
+	if !s.dynamicSizeAllowed(width) || !s.dynamicSizeAllowed(height) {
+		return Preset{}, ErrDynamicSize
+	}
+	return Preset{
+		Name:   fmt.Sprintf("%dx%d_%s", width, height, fit),
+		Width:  width,
+		Height: height,
+		Crop:   crop,
+		// original is served with watermark, so must be any its size
+		Watermark: s.watermark != nil && s.watermark.Original,
+	}, nil
+}
+
+func (s *Service) dynamicSizeAllowed(size uint) bool {
+	if size == 0 || size > s.maxDynamicSize {
+		return false
+	}
+	if len(s.allowedDynamicSizes) == 0 {
+		return true
+	}
+	for _, allowed := range s.allowedDynamicSizes {
+		if size == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// Renders stored original image with given id according to the preset.
+// Rendered image is cached on disk, returns path to it. Least recently
+// used images are removed when cache grows over maxCacheSize.
+func (s *Service) renderDynamic(ctx context.Context, id string, p Preset) (string, error) {
+	meta, err := s.index.Get(id)
+	if os.IsNotExist(err) {
+		return "", err
+	}
+	if err != nil {
+		return "", storageError(err)
+	}
+	p.Format = meta.variant(OriginalVariant).Format
+	path := filepath.Join(s.cacheDir, filepath.Base(imageName(id, p.Name, p.Format)))
+	if _, err := os.Stat(path); err == nil {
+		// modification time is time of the last use
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return path, nil
+	}
+
+	ir, err := s.loadImageResizer(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	defer ir.Close()
+	img, err := ir.GetVariant(p)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(s.cacheDir, 0755); err != nil {
+		return "", err
+	}
+	// write to temporary file first, so concurrent requests
+	// never serve partially written image
+	tmp, err := ioutil.TempFile(s.cacheDir, ".render-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, end := startStage(ctx, stageEncode)
+	err = encodeImage(tmp, img, p)
+	end()
+	if err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	s.evictCache(path)
+	return path, nil
+}
+
+// Removes least recently used images from cache until it fits into
+// maxCacheSize, image at keep is just rendered and isn't removed.
+func (s *Service) evictCache(keep string) {
+	if s.maxCacheSize <= 0 {
+		return
+	}
+	files, err := ioutil.ReadDir(s.cacheDir)
+	if err != nil {
+		return
+	}
+
+	var size int64
+	cached := files[:0]
+	for _, f := range files {
+		// temporary files of renders in progress are left alone
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		size += f.Size()
+		cached = append(cached, f)
+	}
+	sort.Slice(cached, func(i, j int) bool {
+		return cached[i].ModTime().Before(cached[j].ModTime())
+	})
+	for _, f := range cached {
+		if size <= s.maxCacheSize {
+			break
+		}
+		path := filepath.Join(s.cacheDir, f.Name())
+		if path == keep {
+			continue
+		}
+		if err := os.Remove(path); err == nil || os.IsNotExist(err) {
+			size -= f.Size()
+		}
+	}
+}
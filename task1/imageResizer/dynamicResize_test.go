@@ -0,0 +1,181 @@
+package imageResizer
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDynamicImageHandler(t *testing.T) {
+	s := useTestService(t)
+	s.SetDynamicSizeLimits(1000, nil)
+
+	ir, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if _, err := ir.SaveImages(); err != nil {
+		t.Fatal(err)
+	}
+	id := ir.ID()
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/image/{id}").HandlerFunc(s.DynamicImageHandler)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantWidth  int
+		wantHeight int
+	}{
+		{"cover", "/image/"+id+"?w=300&h=100&fit=cover", http.StatusOK, 300, 100},
+		{"default fit", "/image/"+id+"?w=300&h=100", http.StatusOK, 300, 100},
+		{"contain", "/image/"+id+"?w=300&h=100&fit=contain", http.StatusOK, 300, 100},
+		{"fill", "/image/"+id+"?w=300&h=100&fit=fill", http.StatusOK, 300, 100},
+		{"inside", "/image/"+id+"?w=100&h=100&fit=inside", http.StatusOK, 0, 0},
+		{"cached", "/image/"+id+"?w=300&h=100&fit=cover", http.StatusOK, 300, 100},
+		{"too large", "/image/"+id+"?w=3000&h=100", http.StatusBadRequest, 0, 0},
+		{"no width", "/image/"+id+"?h=100", http.StatusBadRequest, 0, 0},
+		{"unknown fit", "/image/"+id+"?w=300&h=100&fit=stretch", http.StatusBadRequest, 0, 0},
+		{"unknown image", "/image/Missing?w=300&h=100", http.StatusNotFound, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Got %v, but expected %v", resp.StatusCode, tt.wantStatus)
+			}
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			img, _, err := image.Decode(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := img.Bounds().Size()
+			if tt.wantWidth == 0 {
+				if size.X > 100 || size.Y > 100 {
+					t.Errorf("Got %vx%v, expected to fit into 100x100", size.X, size.Y)
+				}
+				return
+			}
+			if size.X != tt.wantWidth || size.Y != tt.wantHeight {
+				t.Errorf("Got %vx%v, but expected %vx%v", size.X, size.Y, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+
+	if _, err := os.Stat(filepath.Join(s.cacheDir, id+"_300x100_cover.jpeg")); err != nil {
+		t.Errorf("Rendered image is not cached: %v", err)
+	}
+}
+
+func TestDynamicImageHandler_etag(t *testing.T) {
+	s := useTestService(t)
+	ir, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if err := ir.SaveOriginal(); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/image/{id}").HandlerFunc(s.DynamicImageHandler)
+	url := "/image/" + ir.ID() + "?w=300&h=100"
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v", rec.Code, http.StatusOK)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Error("Got no ETag")
+	}
+	if got := rec.Header().Get("Cache-Control"); got != variantCacheControl {
+		t.Errorf("Got %q, but expected %q", got, variantCacheControl)
+	}
+
+	// image is rendered again after it's evicted from cache
+	os.RemoveAll(s.cacheDir)
+	req := httptest.NewRequest("GET", url, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Got %v, but expected %v", rec.Code, http.StatusNotModified)
+	}
+}
+
+func TestDynamicPreset_allowedSizes(t *testing.T) {
+	s := newService()
+	s.SetDynamicSizeLimits(1000, []uint{100, 200})
+
+	if _, err := s.dynamicPreset(100, 200, ""); err != nil {
+		t.Errorf("Got %v, but expected no error", err)
+	}
+	if _, err := s.dynamicPreset(100, 150, ""); err != ErrDynamicSize {
+		t.Errorf("Got %v, but expected %v", err, ErrDynamicSize)
+	}
+}
+
+func TestRenderDynamic_cacheSize(t *testing.T) {
+	s := useTestService(t)
+	ir, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if err := ir.SaveOriginal(); err != nil {
+		t.Fatal(err)
+	}
+	render := func(fit string) (string, int64) {
+		p, err := s.dynamicPreset(300, 100, fit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, err := s.renderDynamic(context.Background(), ir.ID(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, info.Size()
+	}
+
+	a, sizeA := render("cover")
+	b, sizeB := render("fill")
+	c, sizeC := render("contain")
+	os.Remove(c)
+	// a is used after b, so b is removed to fit c
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(a, old, old)
+	os.Chtimes(b, old.Add(time.Minute), old.Add(time.Minute))
+	s.maxCacheSize = sizeA + sizeB + sizeC - 1
+	render("cover")
+	render("contain")
+
+	for path, want := range map[string]bool{a: true, b: false, c: true} {
+		if _, err := os.Stat(path); (err == nil) != want {
+			t.Errorf("%s: got cached %v, but expected %v", filepath.Base(path), err == nil, want)
+		}
+	}
+}
@@ -1,62 +1,505 @@
 package imageResizer
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 )
 
 
-// Proceeds got image and return links to saved resulting images
-func ImageProcessingHandler(w http.ResponseWriter, r *http.Request) {
+const (
+	// Number of images on page of ListImagesHandler by default and at most
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// Size of multipart form besides image, e.g. boundaries and headers
+	maxFormOverhead = 1024 * 1024
+	// Size of multipart form kept in memory, the rest is stored in temporary files
+	maxMemory = 32 * 1024 * 1024
+	// Original is never changed, because its ID is hash of the content. Other
+	// variants are rendered again when their preset changes, so clients have
+	// to revalidate them by ETag.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	variantCacheControl   = "public, no-cache"
+)
+
+// Proceeds got image and return links to saved images of every preset.
+// Images are stored by hash of their content, so the same image uploaded
+// again isn't rendered twice.
+// With form field async=true only original image is saved and presets are
+// rendered in background, response is the job to poll at GET /image/jobs/{id}.
+// Resampling filter and encoding settings of presets can be overridden by
+// form fields "filter", "quality", "compression", "maxSize" and "progressive",
+// or "<preset>.filter" etc. for single preset. Overridden preset is saved
+// under its own name with hash of settings, see overridePresets. Field
+// "maxSize" limits images saved as JPEG, presets of other formats reject it.
+// Failures are returned as JSON ErrorResponse with status code chosen by errorStatus.
+func (s *Service) ImageProcessingHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxImageSize*1024*1024+maxFormOverhead)
 	file, header, err := r.FormFile("image")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, &TooLargeError{Limit: s.maxImageSize})
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 		return
 	}
 	defer file.Close()
 
-	imageResizer, err := NewImageResizer(file, header.Filename, header.Size)
+	presets, err := overridePresets(s.presets, r.PostForm)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	imageResizer, err := s.newImageResizer(r.Context(), file, header.Filename, header.Size)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer imageResizer.Close()
+
+	if r.FormValue("async") == "true" {
+		s.saveAsync(w, imageResizer, presets)
+		return
+	}
+
+	result, err := imageResizer.SavePresets(presets)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the same image was already uploaded
+	if result.Duplicate {
+		writeJSON(w, http.StatusOK, s.signResult(result))
+	} else {
+		writeJSON(w, http.StatusCreated, s.signResult(result))
+	}
+}
+
+// Proceeds multiple images in one request and returns result or error
+// of every file, failure of one file doesn't fail others.
+// Images are sent as form files "images", zip archives are expanded.
+// Presets can be overridden by the same form fields as in ImageProcessingHandler.
+// POST /images
+func (s *Service) BatchProcessingHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBatchSize*1024*1024+maxFormOverhead)
+	err := r.ParseMultipartForm(maxMemory)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, &TooLargeError{Limit: s.maxBatchSize})
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File["images"]
+	if len(headers) == 0 {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "No images!!!")
+		return
+	}
+
+	presets, err := overridePresets(s.presets, r.PostForm)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	b, err := newBatch(headers)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+	defer b.Close()
+	if len(b.files) > s.maxBatchFiles {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest,
+			fmt.Sprintf("Too many images!!! Maximum %d images in one request.", s.maxBatchFiles))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.processBatch(r.Context(), b, presets))
+}
+
+// Returns page of stored images, newest first.
+// URLs of images are signed, so with signing enabled the request itself
+// has to be signed, e.g. by backend of the client.
+// GET /images?offset=&limit=
+func (s *Service) ListImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	offset, limit := 0, defaultPageSize
+	var err error
+	if s := query.Get("offset"); s != "" {
+		if offset, err = strconv.Atoi(s); err != nil || offset < 0 {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid offset!!!")
+			return
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxPageSize {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("Invalid limit!!! It must be between 1 and %d.", maxPageSize))
+			return
+		}
+	}
+
+	images, total, err := s.index.List(offset, limit)
+	if err != nil {
+		writeError(w, storageError(err))
+		return
+	}
+	for i := range images {
+		images[i] = s.signMetadata(images[i])
+	}
+
+	writeJSON(w, http.StatusOK, ImageList{
+		Images: images,
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+	})
+}
+
+// Returns metadata of stored image and its variants, request is signed
+// the same way as in ListImagesHandler.
+// GET /images/{id}
+func (s *Service) ImageMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	meta, err := s.index.Get(mux.Vars(r)["id"])
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
+	if err != nil {
+		writeError(w, storageError(err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.signMetadata(meta))
+}
+
+// Returns stored images which look like the image, e.g. its resized or
+// re-encoded copies, closest first.
+// GET /images/{id}/similar?distance=, distance is maximum Hamming distance
+// of perceptual hashes from 0 to 64. Request is signed the same way as
+// in ListImagesHandler.
+func (s *Service) SimilarImagesHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	distance := defaultSimilarDistance
+	if v := r.URL.Query().Get("distance"); v != "" {
+		var err error
+		if distance, err = strconv.Atoi(v); err != nil || distance < 0 || distance > maxSimilarDistance {
+			writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest,
+				fmt.Sprintf("Invalid distance!!! It must be between 0 and %d.", maxSimilarDistance))
+			return
+		}
+	}
+
+	id := mux.Vars(r)["id"]
+	meta, err := s.describeImage(r.Context(), id)
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	hash, err := parsePerceptualHash(meta.PerceptualHash)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	similar, err := s.index.Similar(hash, distance)
+	if err != nil {
+		writeError(w, storageError(err))
+		return
+	}
+	images := make([]SimilarImage, 0, len(similar))
+	for _, image := range similar {
+		if image.ID != id {
+			image.Metadata = s.signMetadata(image.Metadata)
+			images = append(images, image)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, SimilarImageList{Images: images})
+}
+
+// Deletes stored image with all its variants. With signing enabled
+// request has to be signed with query parameter delete=true.
+// DELETE /images/{id}
+func (s *Service) DeleteImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	if s.urlSigner != nil && r.URL.Query().Get(deleteParam) != "true" {
+		writeErrorResponse(w, http.StatusForbidden, CodeInvalidSignature, "URL is not signed for deleting!!!")
+		return
+	}
+	err := s.DeleteImage(mux.Vars(r)["id"])
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Saves original image and queues rendering of presets.
+func (s *Service) saveAsync(w http.ResponseWriter, imageResizer *ImageResizer, presets []Preset) {
+	if s.jobs == nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "Asynchronous processing is disabled!!!")
 		return
 	}
 
-	_, err = imageResizer.GetNormalImg()
+	if err := imageResizer.SaveOriginal(); err != nil {
+		writeError(w, err)
+		return
+	}
+	job, err := s.jobs.Submit(imageResizer.ID(), presets)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeError(w, err)
 		return
 	}
 
-	_, err = imageResizer.GetThumbnailImg()
+	w.Header().Set("Location", "/image/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// Returns status of the job and saved images when it's done.
+// GET /image/jobs/{id}
+func (s *Service) JobStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Asynchronous processing is disabled!!!")
+		return
+	}
+
+	job, err := s.jobs.Get(mux.Vars(r)["id"])
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Job not found!!!")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeError(w, err)
 		return
 	}
 
-	nrmlImgPath, origImgPath, tbnlImgPath, err := imageResizer.SaveImages()
+	job.Result = s.signResult(job.Result)
+	writeJSON(w, http.StatusOK, job)
+}
+
+// Serves saved image by its name id_preset.format, the name URL of
+// LocalStorage ends with. Conditional and range requests are supported.
+// GET /files/{name}
+func (s *Service) ServeImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	id, preset, format, ok := parseImageName(mux.Vars(r)["name"])
+	if !ok {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
+	s.serveVariant(w, r, id, preset, format)
+}
+
+// Serves saved image variant of the preset, "original" for original image.
+// URLs returned by the service point here when they are signed.
+// GET /images/{id}/{preset}
+func (s *Service) VariantImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	s.serveVariant(w, r, vars["id"], vars["preset"], "")
+}
+
+// Serves saved variant of the image, format is checked unless it's empty.
+func (s *Service) serveVariant(w http.ResponseWriter, r *http.Request, id, preset, format string) {
+	meta, err := s.index.Get(id)
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeError(w, storageError(err))
+		return
+	}
+	v := meta.variant(preset)
+	if v.Preset == "" || format != "" && v.Format != format {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
 		return
 	}
 
-	result := Result{
-		NrmlImgPath: nrmlImgPath,
-		OrigImgPath: origImgPath,
-		TbnlImgPath: tbnlImgPath,
+	name := imageName(id, preset, v.Format)
+	file, err := s.storage.Open(name)
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
 	}
-	data, err := json.Marshal(result)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("Sorry: " + err.Error()))
+		writeError(w, storageError(err))
 		return
 	}
+	defer file.Close()
+
+	// ranges need seeking, files of local storage can do it,
+	// other storages are read to memory
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			writeError(w, storageError(err))
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	hash := v.Hash
+	if hash == "" {
+		// saved before hashes were kept in metadata
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			writeError(w, storageError(err))
+			return
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			writeError(w, storageError(err))
+			return
+		}
+		hash = hex.EncodeToString(h.Sum(nil))
+	}
+	modified := v.Modified
+	if modified.IsZero() {
+		modified = meta.Created
+	}
 
-	w.WriteHeader(http.StatusCreated)
+	w.Header().Set("ETag", `"`+hash+`"`)
+	if preset == OriginalVariant {
+		w.Header().Set("Cache-Control", immutableCacheControl)
+	} else {
+		w.Header().Set("Cache-Control", variantCacheControl)
+	}
+	http.ServeContent(w, r, name, modified, content)
+}
+
+// Serves stored original image resized on the fly.
+// GET /image/{id}?w=&h=&fit=, fit is one of cover (default), contain, fill, inside.
+func (s *Service) DynamicImageHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.verifySignature(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	width, err := strconv.ParseUint(query.Get("w"), 10, 32)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid width!!!")
+		return
+	}
+	height, err := strconv.ParseUint(query.Get("h"), 10, 32)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, "Invalid height!!!")
+		return
+	}
+
+	preset, err := s.dynamicPreset(uint(width), uint(height), query.Get("fit"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	path, err := s.renderDynamic(r.Context(), id, preset)
+	if os.IsNotExist(err) {
+		writeErrorResponse(w, http.StatusNotFound, CodeNotFound, "Image not found!!!")
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// evicted from cache by other request in between, so it's rendered again
+		if path, err = s.renderDynamic(r.Context(), id, preset); err == nil {
+			file, err = os.Open(path)
+		}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer file.Close()
+
+	// the same size of the image is rendered again when it's evicted from
+	// cache or settings of resizing change, so ETag is hash of the content
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		writeError(w, err)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(h.Sum(nil))+`"`)
+	w.Header().Set("Cache-Control", variantCacheControl)
+	// modification time of cached file is time of its last use,
+	// so Last-Modified isn't sent
+	http.ServeContent(w, r, path, time.Time{}, file)
+}
+
+// Writes value as JSON body with given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(data)
 }
 
+// Writes ErrorResponse with status and code chosen by type of the error.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	writeErrorResponse(w, status, code, err.Error())
+}
+
+// Writes ErrorResponse with given status, error is counted by its code.
+func writeErrorResponse(w http.ResponseWriter, status int, code, message string) {
+	countError(code)
+	data, _ := json.Marshal(ErrorResponse{Code: code, Message: message})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,76 @@
+package imageResizer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Errors returned by ImageResizer, more specific errors like
+// *TooLargeError match them by errors.Is.
+var (
+	ErrUnsupportedFormat = errors.New("Unsupported image format!!!")
+	ErrTooLarge          = errors.New("Image too large!!!")
+	ErrDecode            = errors.New("Image can't be decoded!!!")
+	ErrStorage           = errors.New("Storage failure!!!")
+)
+
+// error codes of ErrorResponse
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeUnsupportedFormat = "unsupported_format"
+	CodeFormatMismatch    = "format_mismatch"
+	CodeTooLarge          = "too_large"
+	CodeTooManyPixels     = "too_many_pixels"
+	CodeMaxSize           = "max_size_exceeded"
+	CodeDecode            = "decode_failed"
+	CodeStorage           = "storage_failed"
+	CodeNotFound          = "not_found"
+	CodeInvalidSignature  = "invalid_signature"
+	CodeExpiredURL        = "url_expired"
+	CodeInternal          = "internal_error"
+)
+
+// Returns HTTP status and code of the error.
+func errorStatus(err error) (int, string) {
+	var (
+		mismatch *FormatMismatchError
+		tooMany  *TooManyPixelsError
+		maxSize  *MaxSizeError
+		maxBytes *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &mismatch):
+		return http.StatusBadRequest, CodeFormatMismatch
+	case errors.As(err, &tooMany):
+		return http.StatusUnprocessableEntity, CodeTooManyPixels
+	case errors.As(err, &maxSize):
+		return http.StatusUnprocessableEntity, CodeMaxSize
+	case errors.Is(err, ErrTooLarge), errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge, CodeTooLarge
+	case errors.Is(err, ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType, CodeUnsupportedFormat
+	case errors.Is(err, ErrDecode):
+		return http.StatusUnprocessableEntity, CodeDecode
+	case errors.Is(err, ErrStorage):
+		return http.StatusServiceUnavailable, CodeStorage
+	case os.IsNotExist(err), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, CodeNotFound
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// Wraps error of image decoder, errors of reading the file are kept.
+func decodeError(err error) error {
+	var tooLarge *TooLargeError
+	if errors.As(err, &tooLarge) {
+		return tooLarge
+	}
+	return fmt.Errorf("%w %v", ErrDecode, err)
+}
+
+// Wraps error of storage or index.
+func storageError(err error) error {
+	return fmt.Errorf("%w %v", ErrStorage, err)
+}
@@ -0,0 +1,135 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Storage which is always unavailable.
+type failingStorage struct{}
+
+func (failingStorage) Save(name string, data io.Reader) (string, error) {
+	return "", errors.New("disk is full")
+}
+
+func (failingStorage) Open(name string) (io.ReadCloser, error) {
+	return nil, errors.New("disk is gone")
+}
+
+func (failingStorage) Delete(name string) error {
+	return errors.New("disk is gone")
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		{"too large", &TooLargeError{Limit: 5}, http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"too many pixels", &TooManyPixelsError{Width: 1, Height: 1, Limit: 0}, http.StatusUnprocessableEntity, CodeTooManyPixels},
+		{"max size", &MaxSizeError{Preset: "a", MaxSize: 100, Size: 200}, http.StatusUnprocessableEntity, CodeMaxSize},
+		{"mismatch", &FormatMismatchError{Declared: JPEG, Actual: PNG}, http.StatusBadRequest, CodeFormatMismatch},
+		{"unsupported", ErrUnsupportedFormat, http.StatusUnsupportedMediaType, CodeUnsupportedFormat},
+		{"decode", decodeError(io.ErrUnexpectedEOF), http.StatusUnprocessableEntity, CodeDecode},
+		{"decode too large", decodeError(&TooLargeError{Limit: 5}), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"storage", storageError(errors.New("timeout")), http.StatusServiceUnavailable, CodeStorage},
+		{"not found", os.ErrNotExist, http.StatusNotFound, CodeNotFound},
+		{"other", errors.New("other"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code := errorStatus(tt.err)
+			if status != tt.wantStatus || code != tt.wantCode {
+				t.Errorf("Got %v %v, but expected %v %v", status, code, tt.wantStatus, tt.wantCode)
+			}
+		})
+	}
+
+	if !errors.Is(&TooManyPixelsError{}, ErrTooLarge) {
+		t.Errorf("Got TooManyPixelsError not matching ErrTooLarge")
+	}
+}
+
+func TestImageProcessingHandler_errors(t *testing.T) {
+
+	jpegData, err := ioutil.ReadFile("testdata/JPEGImage.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pngData, err := ioutil.ReadFile("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		fileName   string
+		data       []byte
+		fields     map[string]string
+		storage    Storage
+		maxPixels  int64
+		wantStatus int
+		wantCode   string
+	}{
+		{"no image", "", nil, nil, nil, 0, http.StatusBadRequest, CodeInvalidRequest},
+		{"invalid preset", "image.png", pngData, map[string]string{"quality": "500"}, nil, 0, http.StatusBadRequest, CodeInvalidRequest},
+		{"unsupported format", "image.txt", []byte("just some text"), nil, nil, 0, http.StatusUnsupportedMediaType, CodeUnsupportedFormat},
+		{"format mismatch", "image.jpg", pngData, nil, nil, 0, http.StatusBadRequest, CodeFormatMismatch},
+		{"too large", "image.jpg", bytes.Repeat(jpegData, 3*1024*1024/len(jpegData)+1), nil, nil, 0, http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"too many pixels", "image.jpg", jpegData, nil, nil, 100, http.StatusUnprocessableEntity, CodeTooManyPixels},
+		{"decode failure", "image.jpg", jpegData[:len(jpegData)/2], nil, nil, 0, http.StatusUnprocessableEntity, CodeDecode},
+		{"storage failure", "image.png", pngData, nil, failingStorage{}, 0, http.StatusServiceUnavailable, CodeStorage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useTestService(t)
+			s.SetLimits(2, 50*1000*1000)
+			if tt.maxPixels != 0 {
+				s.SetLimits(2, tt.maxPixels)
+			}
+			if tt.storage != nil {
+				s.SetStorage(tt.storage)
+			}
+
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			for key, value := range tt.fields {
+				writer.WriteField(key, value)
+			}
+			if tt.data != nil {
+				part, _ := writer.CreateFormFile("image", tt.fileName)
+				part.Write(tt.data)
+			}
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/image", body)
+			req.Header.Set("Content-type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.ImageProcessingHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Got %v, but expected %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Got %v, but expected application/json", contentType)
+			}
+			resp := ErrorResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode || resp.Message == "" {
+				t.Errorf("Got %+v, but expected code %v", resp, tt.wantCode)
+			}
+		})
+	}
+}
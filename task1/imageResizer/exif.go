@@ -0,0 +1,168 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// Sets whether Exif metadata is stripped from saved original image.
+// Metadata is only kept for images saved as JPEG.
+func (s *Service) SetStripMetadata(strip bool) {
+	s.stripMetadata = strip
+}
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// Exif tags
+const (
+	orientationTag = 0x0112
+)
+
+// withExif is image which is encoded with Exif metadata.
+type withExif struct {
+	image.Image
+	exif []byte
+}
+
+// Returns Exif payload (TIFF structure) of the JPEG APP1 segment,
+// nil if there is no such segment.
+func jpegExif(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		// start of scan, there are no more metadata segments
+		if marker == 0xDA {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		i = end
+	}
+	return nil
+}
+
+// Returns JPEG data with Exif payload inserted as APP1 segment after SOI marker.
+func insertJpegExif(data, exif []byte) []byte {
+	length := 2 + len(exifHeader) + len(exif)
+	if len(data) < 2 || length > 0xFFFF {
+		return data
+	}
+	result := make([]byte, 0, len(data)+2+length)
+	result = append(result, data[:2]...)
+	result = append(result, 0xFF, 0xE1, byte(length>>8), byte(length))
+	result = append(result, exifHeader...)
+	result = append(result, exif...)
+	return append(result, data[2:]...)
+}
+
+// Returns offset of the value of IFD0 entry with given tag
+// in TIFF structure, -1 if there is no such entry.
+func tiffTagOffset(tiff []byte, tag uint16) (int, binary.ByteOrder) {
+	if len(tiff) < 8 {
+		return -1, nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return -1, nil
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return -1, nil
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return -1, nil
+		}
+		if order.Uint16(tiff[entry:]) == tag {
+			return entry + 8, order
+		}
+	}
+	return -1, nil
+}
+
+// Returns orientation (1-8) stored in TIFF structure, 1 if it's missing.
+func exifOrientation(tiff []byte) int {
+	offset, order := tiffTagOffset(tiff, orientationTag)
+	if offset < 0 {
+		return 1
+	}
+	orientation := int(order.Uint16(tiff[offset:]))
+	if orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// Sets orientation stored in TIFF structure if it's present.
+func setExifOrientation(tiff []byte, orientation int) {
+	offset, order := tiffTagOffset(tiff, orientationTag)
+	if offset < 0 {
+		return
+	}
+	order.PutUint16(tiff[offset:], uint16(orientation))
+}
+
+// Returns image transformed according to Exif orientation,
+// so it is displayed correctly without metadata.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+
+	// source is converted by rows, so the rotated image is the only
+	// full size copy, memory budget counts it with decoded image
+	src := image.NewRGBA(image.Rect(0, 0, w, 1))
+	for y := 0; y < h; y++ {
+		draw.Draw(src, src.Bounds(), img, image.Pt(b.Min.X, b.Min.Y+y), draw.Src)
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[x*4:x*4+4])
+		}
+	}
+	return dst
+}
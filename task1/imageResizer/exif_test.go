@@ -0,0 +1,134 @@
+package imageResizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Fixtures are 60x40 images stored with red top left corner
+// and Exif orientation telling how to rotate them.
+var orientationImages = []struct {
+	name          string
+	filepath      string
+	wantWidth     int
+	wantHeight    int
+	wantRedCorner image.Point
+}{
+	{"rotated 180", "testdata/Orientation3.jpg", 60, 40, image.Pt(59, 39)},
+	{"rotated 90 CW", "testdata/Orientation6.jpg", 40, 60, image.Pt(39, 0)},
+	{"rotated 90 CCW", "testdata/Orientation8.jpg", 40, 60, image.Pt(0, 59)},
+}
+
+func TestNewImageResizer_orientation(t *testing.T) {
+	for _, tt := range orientationImages {
+		t.Run(tt.name, func(t *testing.T) {
+			ir, err := imageResizerFromImagePath(tt.filepath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ir.Close()
+			img := ir.GetOriginalImg()
+			if size := img.Bounds().Size(); size.X != tt.wantWidth || size.Y != tt.wantHeight {
+				t.Fatalf("Got %vx%v, but expected %vx%v", size.X, size.Y, tt.wantWidth, tt.wantHeight)
+			}
+			if r, g, b, _ := img.At(tt.wantRedCorner.X, tt.wantRedCorner.Y).RGBA(); r < 0xC000 || g > 0x4000 || b > 0x4000 {
+				t.Errorf("Expected red corner at %v", tt.wantRedCorner)
+			}
+		})
+	}
+}
+
+func TestNewImageResizer_orientationBudget(t *testing.T) {
+	s := useTestService(t)
+	data := readTestFile(t, "testdata/Orientation6.jpg")
+	size := decodedSize(60, 40, 1)
+	s.decodeBudget = newMemorySemaphore(3 * size)
+
+	// rotated copy is counted while image is decoded
+	held, _ := s.decodeBudget.acquire(context.Background(), 3*size/2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.newImageResizer(ctx, bytes.NewReader(data), "image.jpg", 0); err != context.DeadlineExceeded {
+		t.Errorf("Got %v, but expected %v", err, context.DeadlineExceeded)
+	}
+	s.decodeBudget.release(held)
+
+	// and released when only the rotated image is left
+	ir, err := s.NewImageResizer(bytes.NewReader(data), "image.jpg", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if s.decodeBudget.taken != size {
+		t.Errorf("Got %v, but expected %v", s.decodeBudget.taken, size)
+	}
+}
+
+func TestApplyOrientation_subImage(t *testing.T) {
+	// image which doesn't start at 0,0
+	img := testPattern(40, 40).SubImage(image.Rect(13, 5, 32, 30))
+	whole := image.NewRGBA(image.Rect(0, 0, 19, 25))
+	draw.Draw(whole, whole.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	for orientation := 2; orientation <= 8; orientation++ {
+		got := applyOrientation(img, orientation).(*image.RGBA)
+		want := applyOrientation(whole, orientation).(*image.RGBA)
+		if got.Bounds() != want.Bounds() || !bytes.Equal(got.Pix, want.Pix) {
+			t.Errorf("%v: got other image than of the same pixels at 0,0", orientation)
+		}
+	}
+}
+
+func TestImageResizer_SaveImages_metadata(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		strip    bool
+		wantExif bool
+	}{
+		{"stripped", true, false},
+		{"kept", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := useTestService(t)
+			s.SetStripMetadata(tt.strip)
+			root := t.TempDir()
+			s.SetStorage(NewLocalStorage(root, ""))
+			ir, err := imageResizerFromImagePath("testdata/Orientation6.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ir.Close()
+			if _, err := ir.SaveImages(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := ioutil.ReadFile(root + "/" + ir.ID() + "_original.jpeg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			exif := jpegExif(data)
+			if !tt.wantExif {
+				if exif != nil {
+					t.Error("Expected Exif metadata to be stripped")
+				}
+				return
+			}
+			if exif == nil {
+				t.Fatal("Expected Exif metadata to be kept")
+			}
+			if o := exifOrientation(exif); o != 1 {
+				t.Errorf("Got orientation %v, but expected 1 for already rotated image", o)
+			}
+			if offset, _ := tiffTagOffset(exif, 0x8825); offset < 0 {
+				t.Error("Expected GPS metadata to be kept")
+			}
+		})
+	}
+}
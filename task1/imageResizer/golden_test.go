@@ -0,0 +1,188 @@
+package imageResizer
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// go test -run TestGolden -update renders golden images again, they have
+// to be looked through before they are committed
+var update = flag.Bool("update", false, "update golden images in testdata/golden")
+
+const (
+	goldenDir = "testdata/golden"
+	// Pixel differs from golden one if any of its channels differs
+	// by more than goldenChannelTolerance
+	goldenChannelTolerance = 8
+	// Share of pixels which may differ, resize filters and encoders
+	// of other versions may round a bit differently
+	goldenMaxDiffPixels = 0.01
+)
+
+// Renders every test image by every preset and compares saved
+// variants with golden images.
+func TestGolden(t *testing.T) {
+	useTestService(t)
+	presets, err := LoadPresets("testdata/presets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	presets = append([]Preset{NormalPreset, ThumbnailPreset}, presets...)
+	if *update {
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range testImages {
+		t.Run(tt.name, func(t *testing.T) {
+			ir, err := imageResizerFromImagePath(tt.filepath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ir.Close()
+			result, err := ir.SavePresets(presets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the first variant is original image
+			for _, v := range result.Variants[1:] {
+				t.Run(v.Preset, func(t *testing.T) {
+					// JPEG variants are kept as they are, other formats are
+					// lossless and PNG is the smallest of them
+					got := decodeLocal(t, localPath(v.URL))
+					golden := filepath.Join(goldenDir, tt.name+"_"+v.Preset)
+					if v.Format == JPEG {
+						golden += ".jpeg"
+					} else {
+						golden += ".png"
+					}
+					if *update {
+						if v.Format == JPEG {
+							copyFile(t, localPath(v.URL), golden)
+						} else {
+							writePNG(t, golden, got)
+						}
+						return
+					}
+					compareGolden(t, got, decodeLocal(t, golden))
+				})
+			}
+		})
+	}
+}
+
+func TestCompareImages(t *testing.T) {
+	gray := uniform(10, 10, color.Gray{100})
+	slightlyLighter := uniform(10, 10, color.Gray{100 + goldenChannelTolerance})
+	oneDot := uniform(10, 10, color.Gray{100})
+	oneDot.Set(5, 5, color.White)
+	manyDots := uniform(10, 10, color.Gray{100})
+	for x := 0; x < 10; x++ {
+		manyDots.Set(x, 5, color.White)
+	}
+
+	tests := []struct {
+		name      string
+		got, want image.Image
+		wantOK    bool
+	}{
+		{"same", gray, gray, true},
+		{"within tolerance", slightlyLighter, gray, true},
+		{"one pixel", oneDot, gray, true},
+		{"many pixels", manyDots, gray, false},
+		{"other size", uniform(10, 11, color.Gray{100}), gray, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareImages(tt.got, tt.want) == nil; got != tt.wantOK {
+				t.Errorf("Got %v, but expected %v", got, tt.wantOK)
+			}
+		})
+	}
+}
+
+// Fails the test if got differs from golden image, got is kept in
+// temporary directory which isn't removed after the test, so it can
+// be looked at.
+func compareGolden(t *testing.T, got, want image.Image) {
+	if err := compareImages(got, want); err != nil {
+		dir, dirErr := ioutil.TempDir("", "golden-")
+		if dirErr != nil {
+			t.Fatal(dirErr)
+		}
+		path := filepath.Join(dir, "got.png")
+		writePNG(t, path, got)
+		t.Errorf("%v, rendered image is kept at %s, run with -update if the change is expected", err, path)
+	}
+}
+
+// Returns error if images have different size or too many pixels differ.
+func compareImages(got, want image.Image) error {
+	gb, wb := got.Bounds(), want.Bounds()
+	if gb.Size() != wb.Size() {
+		return fmt.Errorf("Got %v image, but expected %v", gb.Size(), wb.Size())
+	}
+
+	diff := 0
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			g := color.NRGBA64Model.Convert(got.At(gb.Min.X+x, gb.Min.Y+y)).(color.NRGBA64)
+			w := color.NRGBA64Model.Convert(want.At(wb.Min.X+x, wb.Min.Y+y)).(color.NRGBA64)
+			if channelDiff(g.R, w.R) || channelDiff(g.G, w.G) || channelDiff(g.B, w.B) || channelDiff(g.A, w.A) {
+				diff++
+			}
+		}
+	}
+	if share := float64(diff) / float64(gb.Dx()*gb.Dy()); share > goldenMaxDiffPixels {
+		return fmt.Errorf("Got %.2f%% different pixels, but expected at most %.2f%%", share*100, goldenMaxDiffPixels*100)
+	}
+	return nil
+}
+
+func channelDiff(a, b uint16) bool {
+	d := int(a>>8) - int(b>>8)
+	return d > goldenChannelTolerance || d < -goldenChannelTolerance
+}
+
+func decodeLocal(t *testing.T, path string) image.Image {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func copyFile(t *testing.T, from, to string) {
+	data, err := ioutil.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(to, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := enc.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,178 +1,756 @@
 package imageResizer
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/nfnt/resize"
-	"github.com/oliamb/cutter"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 	"image"
-	"image/jpeg"
+	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/png"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
-)
-
-var (
-	// path for saving images locally
-	savingPath = "/home/dheyoungb/go/src/github.com/dairovolzhas/dar-internship/task1/imageResizer/resizedImages/"
-	// Size of normal image
-	normalWidth, normalHeight uint = 800, 800
-	// Size of thumbnail image
-	thumbnailWidth, thumbnailHeight uint = 200, 200
-	// Maximum image size in megabytes
-	maxImageSize int64 = 5
+	"sync"
+	"time"
 )
 
 // image formats
 const (
-	JPG = "jpg"
+	JPG  = "jpg"
 	JPEG = "jpeg"
-	PNG = "png"
+	PNG  = "png"
+	GIF  = "gif"
+	WEBP = "webp"
+	BMP  = "bmp"
+	TIF  = "tif"
+	TIFF = "tiff"
 )
 
+// image formats by file extension
+var extensionFormats = map[string]string{
+	"." + JPG:  JPEG,
+	"." + JPEG: JPEG,
+	"." + PNG:  PNG,
+	"." + GIF:  GIF,
+	"." + WEBP: WEBP,
+	"." + BMP:  BMP,
+	"." + TIF:  TIFF,
+	"." + TIFF: TIFF,
+}
+
+// formats images can be uploaded in
+var decodableFormats = map[string]bool{
+	JPEG: true,
+	PNG:  true,
+	GIF:  true,
+	WEBP: true,
+	BMP:  true,
+	TIFF: true,
+}
+
+// FormatMismatchError is returned when file extension doesn't match
+// real format of the image.
+type FormatMismatchError struct {
+	Declared string
+	Actual   string
+}
+
+func (e *FormatMismatchError) Error() string {
+	return fmt.Sprintf("Image declared as %s, but it is %s!!!", e.Declared, e.Actual)
+}
+
 // Returns ImageResizer which contains only original image and their
-// field such as imageFormat and fileName. Normal and thumbnail sized images
+// field such as imageFormat and fileName. Resized images
 // will be proceed when needed it.
-func NewImageResizer(file io.Reader, fileName string, fileSize int64) (ir *ImageResizer, err error) {
+// Image format is detected by content, file extension only has to agree with it.
+// Declared fileSize is checked up front, but actual size of file is enforced
+// while reading as well as number of pixels before image is decoded.
+// Image is saved by service with default settings.
+// Decoded image takes memory budget of the service, so ImageResizer
+// has to be closed when it's not needed anymore.
+func NewImageResizer(file io.Reader, fileName string, fileSize int64) (*ImageResizer, error) {
+	return defaultService.NewImageResizer(file, fileName, fileSize)
+}
+
+// Same as NewImageResizer, but image is decoded with limits of
+// the service and saved by it.
+func (s *Service) NewImageResizer(file io.Reader, fileName string, fileSize int64) (*ImageResizer, error) {
+	return s.newImageResizer(context.Background(), file, fileName, fileSize)
+}
+
+// Same as NewImageResizer of the service, spans of processing stages
+// of the image are children of ctx, e.g. of request it's uploaded by.
+func (s *Service) newImageResizer(ctx context.Context, file io.Reader, fileName string, fileSize int64) (ir *ImageResizer, err error) {
 	// check for image size
-	if fileSize > maxImageSize*1024*1024 {
-		return nil, errors.New(fmt.Sprintf("Image too large!!! Maximum file size %d MB.", maxImageSize))
-	}
-
-	ir = &ImageResizer{}
-
-	// Determine image format and decode.
-	switch  {
-	case strings.HasSuffix(fileName, ".jpeg") || strings.HasSuffix(fileName, ".jpg"):
-		//if strings.HasSuffix(fileName, ".jpeg"){
-		//} else {
-		//	ir.imageFormat = JPG
-		//}
-		ir.imageFormat = JPEG
-		ir.fileName = fileName[:len(fileName)-len(ir.imageFormat)-1]
-		ir.originalImg, err = jpeg.Decode(file)
+	if fileSize > s.maxImageSize*1024*1024 {
+		return nil, &TooLargeError{Limit: s.maxImageSize}
+	}
+	limited := newSizeLimitedReader(file, s.maxImageSize)
+	hash := sha256.New()
+	file = io.TeeReader(limited, hash)
+
+	ir = &ImageResizer{
+		svc:      s,
+		ctx:      ctx,
+		variants: map[string]image.Image{},
+	}
+
+	// Determine image format by magic bytes. Bytes read for this
+	// are kept in header and read again by decoder.
+	var header bytes.Buffer
+	config, format, err := image.DecodeConfig(io.TeeReader(file, &header))
+	if err == image.ErrFormat {
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	if !decodableFormats[format] {
+		return nil, ErrUnsupportedFormat
+	}
+	if err := checkPixels(config.Width, config.Height, 1, s.maxImagePixels); err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(fileName)
+	if declared, ok := extensionFormats[strings.ToLower(ext)]; ok && declared != format {
+		return nil, &FormatMismatchError{Declared: declared, Actual: format}
+	}
+	ir.imageFormat = format
+	ir.fileName = strings.TrimSuffix(fileName, ext)
+
+	data := io.MultiReader(&header, file)
+	frames := 1
+	if format == GIF {
+		// all frames are decoded at once and composed to full size
+		// images, so they are counted before anything is decoded
+		gifData, err := ioutil.ReadAll(data)
 		if err != nil {
+			return nil, decodeError(err)
+		}
+		if frames, err = gifFrames(gifData); err != nil {
+			return nil, decodeError(err)
+		}
+		if err := checkPixels(config.Width, config.Height, frames, s.maxImagePixels); err != nil {
 			return nil, err
 		}
-	case strings.HasSuffix(fileName, ".png"):
-		ir.imageFormat = PNG
-		ir.fileName = fileName[:len(fileName)-len(ir.imageFormat)-1]
-		ir.originalImg, err = png.Decode(file)
+		data = bytes.NewReader(gifData)
+	}
+
+	// image is rotated to a copy of the same size, decoded image
+	// is held with it until rotation is done
+	size := decodedSize(config.Width, config.Height, frames)
+	orientation := ir.readOrientation(format, header.Bytes())
+	rotated := int64(0)
+	if orientation > 1 {
+		rotated = size
+	}
+
+	// decoded image is held until Close, so memory budget limits
+	// images which are decoded and rendered at once
+	// waiting for the budget stops when request is canceled
+	ir.budget = s.decodeBudget
+	if ir.taken, err = ir.budget.acquire(ctx, size+rotated); err != nil {
+		return nil, err
+	}
+	decoded := ir
+	defer func() {
 		if err != nil {
-			return nil, err
+			decoded.Close()
 		}
-	default:
-		return nil, errors.New("Unsupported image format!!!")
+	}()
+
+	_, end := startStage(ctx, stageDecode)
+	if format == GIF {
+		err = ir.decodeGIF(data)
+	} else {
+		err = ir.decode(data, orientation)
 	}
+	end()
+	if err != nil {
+		return nil, err
+	}
+	// only the rotated image is left
+	if extra := ir.taken - size; extra > 0 {
+		ir.budget.release(extra)
+		ir.taken = size
+	}
+	imagesDecoded.WithLabelValues(format).Inc()
+
+	// image is identified by hash of whole file, not only the part read by decoder
+	if _, err := io.Copy(ioutil.Discard, file); err != nil {
+		return nil, err
+	}
+	ir.id = hex.EncodeToString(hash.Sum(nil)[:16])
+	ir.fileSize = limited.read()
 
 	return ir, nil
 }
 
+// Releases memory budget taken by decoded image. ImageResizer
+// isn't used after it, closing it again does nothing.
+func (ir *ImageResizer) Close() {
+	ir.mu.Lock()
+	taken := ir.taken
+	ir.taken = 0
+	ir.mu.Unlock()
+	if taken > 0 {
+		ir.budget.release(taken)
+	}
+}
+
+// Decodes GIF image, it may be animated, so all its frames are kept.
+// Number of frames is already checked by gifFrames.
+func (ir *ImageResizer) decodeGIF(data io.Reader) error {
+	g, err := gif.DecodeAll(data)
+	if err != nil {
+		return decodeError(err)
+	}
+	if len(g.Image) > 1 {
+		ir.originalImg = newAnimation(g)
+	} else {
+		ir.originalImg = g.Image[0]
+	}
+	return nil
+}
+
+// Returns Exif orientation found in header bytes, Exif of JPEG
+// is kept to be saved with the image.
+func (ir *ImageResizer) readOrientation(format string, header []byte) int {
+	// Camera saves pixels as they are read from sensor and tells how
+	// image has to be rotated in Exif orientation, JPEG keeps it in APP1
+	// segment and TIFF is Exif structure itself.
+	orientation := 1
+	switch format {
+	case JPEG:
+		if exif := jpegExif(header); exif != nil {
+			ir.exif = append([]byte(nil), exif...)
+			orientation = exifOrientation(ir.exif)
+			// saved image is already rotated
+			setExifOrientation(ir.exif, 1)
+		}
+	case TIFF:
+		orientation = exifOrientation(header)
+	}
+	return orientation
+}
+
+// Decodes image and rotates it according to Exif orientation.
+func (ir *ImageResizer) decode(data io.Reader, orientation int) error {
+	img, _, err := image.Decode(data)
+	if err != nil {
+		return decodeError(err)
+	}
+	ir.originalImg = applyOrientation(img, orientation)
+	return nil
+}
+
+// Returns ImageResizer of the saved original image with given ID,
+// it has to be closed as well as one of NewImageResizer.
+func (s *Service) LoadImageResizer(id string) (*ImageResizer, error) {
+	return s.loadImageResizer(context.Background(), id)
+}
+
+// Same as LoadImageResizer, spans of processing stages are children of ctx.
+func (s *Service) loadImageResizer(ctx context.Context, id string) (*ImageResizer, error) {
+	meta, err := s.index.Get(id)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	original := meta.variant(OriginalVariant)
+	name := imageName(id, OriginalVariant, original.Format)
+	if meta.Source != "" {
+		// original is stamped, variants are rendered without watermark
+		name = meta.Source
+	}
+
+	file, err := s.storage.Open(name)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+	defer file.Close()
+
+	ir, err := s.newImageResizer(ctx, file, name, 0)
+	if err != nil {
+		return nil, err
+	}
+	// saved original is encoded again, so it has other hash
+	ir.id = meta.ID
+	ir.fileName = meta.FileName
+	ir.imageFormat = meta.Format
+	ir.fileSize = meta.Size
+	ir.loaded = true
+	return ir, nil
+}
+
+// Returns ID of the image, it's derived from content of uploaded file,
+// so the same file always gets the same ID.
+func (ir *ImageResizer) ID() string {
+	return ir.id
+}
+
 // Returns original image without changes.
 func (ir *ImageResizer) GetOriginalImg() image.Image {
 	return ir.originalImg
 }
 
-// Returns resized image via downscaling normal image.
+// Returns resized image of thumbnail size.
 func (ir *ImageResizer) GetThumbnailImg() (image.Image, error) {
-	if ir.thumbnailImg == nil {
-		if ir.normalImg == nil {
-			_, err := ir.GetNormalImg()
-			if err != nil {
-				return nil, err
-			}
-		}
-		ir.thumbnailImg = resize.Thumbnail(thumbnailWidth, thumbnailHeight, ir.normalImg, resize.NearestNeighbor)
-	}
-	return ir.thumbnailImg, nil
+	return ir.GetVariant(ThumbnailPreset)
 }
 
 // Returns cropped by center and resized image of normal size.
-// There are checking for existing of normal image.
 func (ir *ImageResizer) GetNormalImg() (image.Image, error) {
-	if ir.normalImg == nil {
-		size := ir.originalImg.Bounds().Size()
+	return ir.GetVariant(NormalPreset)
+}
+
+// Returns original image resized according to the preset.
+// There are checking for existing of resized image.
+// It's safe to call concurrently for different presets.
+func (ir *ImageResizer) GetVariant(p Preset) (image.Image, error) {
+	ir.mu.Lock()
+	img, ok := ir.variants[p.Name]
+	ir.mu.Unlock()
+	if ok {
+		return img, nil
+	}
+
+	var err error
+	if a, ok := ir.originalImg.(*animation); ok {
+		img, err = a.resize(ir.ctx, p)
+	} else {
+		img, err = resizeImage(ir.ctx, ir.originalImg, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w := ir.svc.watermark; p.Watermark && w != nil {
+		img = w.apply(img)
+	}
+
+	ir.mu.Lock()
+	ir.variants[p.Name] = img
+	ir.mu.Unlock()
+	return img, nil
+}
+
+// Returns image resized according to the preset. Cropping and resizing
+// are measured as stages of processing with spans children of ctx.
+func resizeImage(ctx context.Context, original image.Image, p Preset) (image.Image, error) {
+	filter := p.interpolation()
+	switch p.Crop {
+	case CropNone:
+		_, end := startStage(ctx, stageResize)
+		defer end()
+		return resize.Thumbnail(p.Width, p.Height, original, filter), nil
+	case CropFill:
+		_, end := startStage(ctx, stageResize)
+		defer end()
+		return resize.Resize(p.Width, p.Height, original, filter), nil
+	case CropContain:
+		_, end := startStage(ctx, stageResize)
+		defer end()
+		width, height := fitSize(original.Bounds().Size(), p.Width, p.Height)
+		resized := resize.Resize(width, height, original, filter)
+		return letterbox(resized, p.Width, p.Height, p.backgroundColor()), nil
+	default:
+		// CropCenter, CropTop, CropFocal and CropEntropy
+		_, end := startStage(ctx, stageCrop)
+		croppedImg := cropImage(original, cropRect(original, p))
+		end()
+
+		_, end = startStage(ctx, stageResize)
+		defer end()
+		return resize.Resize(p.Width, p.Height, croppedImg, filter), nil
+	}
+}
+
+// Returns the biggest size of src aspect ratio which fits into width x height.
+func fitSize(src image.Point, width, height uint) (uint, uint) {
+	w, h := uint64(src.X), uint64(src.Y)
+	if w*uint64(height) > h*uint64(width) {
+		w, h = uint64(width), h*uint64(width)/w
+	} else {
+		w, h = w*uint64(height)/h, uint64(height)
+	}
+	if w == 0 {
+		w = 1
+	}
+	if h == 0 {
+		h = 1
+	}
+	return uint(w), uint(h)
+}
+
+// Draws image in the center of width x height canvas filled with background.
+func letterbox(img image.Image, width, height uint, background color.Color) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	size := img.Bounds().Size()
+	offset := image.Pt((int(width)-size.X)/2, (int(height)-size.Y)/2)
+	draw.Draw(canvas, image.Rectangle{offset, offset.Add(size)}, img, img.Bounds().Min, draw.Over)
+	return canvas
+}
+
+// Renders configured presets and saves them with original image to the storage.
+func (ir *ImageResizer) SaveImages() (*Result, error) {
+	return ir.SavePresets(ir.svc.presets)
+}
 
-		width, height := size.X, size.Y
+// Saves original image and its metadata, unless image with the same ID
+// was already saved. Presets are rendered later by SavePresets.
+func (ir *ImageResizer) SaveOriginal() error {
+	defer ir.svc.imageLocks.lock(ir.id)()
+	_, _, err := ir.saveOriginal()
+	return err
+}
+
+// Returns metadata of the image, original image is saved if it's new.
+// Image has to be locked by imageLocks.
+func (ir *ImageResizer) saveOriginal() (meta *Metadata, created bool, err error) {
+	s := ir.svc
+	meta, err = s.index.Get(ir.id)
+	if err == nil {
+		return meta, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, storageError(err)
+	}
+	if ir.loaded {
+		// deleted after it was loaded, e.g. by job
+		return nil, false, err
+	}
+
+	size := ir.originalImg.Bounds().Size()
+	meta = &Metadata{
+		ID:       ir.id,
+		FileName: ir.fileName,
+		Format:   ir.imageFormat,
+		Width:    size.X,
+		Height:   size.Y,
+		Size:     ir.fileSize,
+		Created:  time.Now().UTC(),
+		Presets:  map[string]Preset{},
+	}
+	if _, err := ir.describe(meta); err != nil {
+		return nil, false, err
+	}
 
-		config := cutter.Config{
-			Width:   min(width, height),
-			Height:  min(width, height),
-			Mode:    cutter.Centered,
+	var originalImg image.Image = ir.originalImg
+	format := s.outputFormat(ir.imageFormat)
+	if s.watermark != nil && s.watermark.Original {
+		source := Preset{Name: SourceVariant, Format: format}
+		if _, err := s.saveImage(ir.ctx, ir.originalImg, imageName(ir.id, SourceVariant, format), source); err != nil {
+			return nil, false, err
 		}
+		meta.Source = imageName(ir.id, SourceVariant, format)
+		originalImg = s.watermark.apply(ir.originalImg)
+	}
+	if ir.exif != nil && !s.stripMetadata && format == JPEG {
+		originalImg = &withExif{Image: originalImg, exif: ir.exif}
+	}
+	original, err := s.saveVariant(ir.ctx, originalImg, ir.id, Preset{Name: OriginalVariant, Format: format})
+	if err != nil {
+		return nil, false, err
+	}
+	meta.Variants = []Variant{original}
 
-		croppedImg, err := cutter.Crop(ir.originalImg, config)
-		if err != nil {
-			return nil, err
+	if err := s.index.Put(meta); err != nil {
+		return nil, false, storageError(err)
+	}
+	return meta, true, nil
+}
+
+// Renders given presets and saves them with original image to the storage.
+// Images are saved under ID of the image, if it was already saved, only presets
+// which are missing or had other settings are rendered.
+// Returns saved variants, original image comes first.
+// Image is locked until variants are saved, so concurrent uploads and
+// deleting of the same image don't lose changes of each other.
+func (ir *ImageResizer) SavePresets(presets []Preset) (*Result, error) {
+	defer ir.svc.imageLocks.lock(ir.id)()
+	meta, created, err := ir.saveOriginal()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		ID:       ir.id,
+		Variants: []Variant{meta.variant(OriginalVariant)},
+	}
+	changed, err := ir.describe(meta)
+	if err != nil {
+		return nil, err
+	}
+	result.Placeholder = meta.Placeholder
+
+	// presets which have to be rendered, by position in result
+	render := map[int]Preset{}
+	for _, p := range presets {
+		if p.Format == "" {
+			p.Format = ir.svc.outputFormat(ir.imageFormat)
 		}
+		if stored, ok := meta.Presets[p.Name]; ok && stored == p {
+			result.Variants = append(result.Variants, meta.variant(p.Name))
+			continue
+		}
+		render[len(result.Variants)] = p
+		result.Variants = append(result.Variants, Variant{})
+	}
 
-		ir.normalImg = resize.Resize(normalWidth, normalHeight, croppedImg, resize.NearestNeighbor)
+	variants, err := ir.renderVariants(render)
+	if err != nil {
+		return nil, err
 	}
+	for i := range result.Variants {
+		if v, ok := variants[i]; ok {
+			meta.setVariant(render[i], v)
+			result.Variants[i] = v
+		}
+	}
+	rendered := len(render) > 0
 
-	return ir.normalImg, nil
+	if rendered || changed {
+		if err := ir.svc.index.Put(meta); err != nil {
+			return nil, storageError(err)
+		}
+	}
+
+	result.Duplicate = !created && !rendered
+	return result, nil
 }
-// Saves images.
-// Returns paths to saved images.
-func (ir *ImageResizer) SaveImages() (nrmlImgPath, origImgPath, tbnlImgPath string, err error) {
-	nrmlImgPath, err = saveImage(ir.normalImg, ir.fileName+ "_normal", ir.imageFormat)
+
+// Returns metadata of saved image with given ID, placeholder and
+// perceptual hash are made and saved if they are missing.
+func (s *Service) describeImage(ctx context.Context, id string) (*Metadata, error) {
+	defer s.imageLocks.lock(id)()
+	meta, err := s.index.Get(id)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
 	if err != nil {
-		return
+		return nil, storageError(err)
+	}
+	if meta.PerceptualHash != "" {
+		return meta, nil
 	}
-	origImgPath, err = saveImage(ir.originalImg, ir.fileName+ "_original", ir.imageFormat)
+
+	// saved before hashes were kept in metadata
+	ir, err := s.loadImageResizer(ctx, id)
 	if err != nil {
-		return
+		return nil, err
 	}
-	tbnlImgPath, err = saveImage(ir.thumbnailImg, ir.fileName+ "_thumbnail", ir.imageFormat)
+	_, err = ir.describe(meta)
+	ir.Close()
 	if err != nil {
-		return
+		return nil, err
+	}
+	if err := s.index.Put(meta); err != nil {
+		return nil, storageError(err)
+	}
+	return meta, nil
+}
+
+// Sets placeholder and perceptual hash of the image which are missing in
+// metadata, e.g. image was saved before they were made. Returns false
+// if nothing was missing.
+func (ir *ImageResizer) describe(meta *Metadata) (bool, error) {
+	changed := false
+	if meta.Placeholder == nil {
+		p, err := ir.Placeholder()
+		if err != nil {
+			return false, err
+		}
+		meta.Placeholder = p
+		changed = true
+	}
+	if meta.PerceptualHash == "" {
+		meta.PerceptualHash = formatPerceptualHash(perceptualHash(ir.originalImg))
+		changed = true
 	}
-	return
+	return changed, nil
 }
 
-// Returns path where image saved.
-func saveImage(image image.Image, name string, imageFormat string) (string, error) {
-	// getting relative path between runtime and image saving dirs
-	cur, err := os.Getwd()
+// Renders and saves presets in parallel by renderWorkers goroutines.
+// Returns saved variants by the same keys as presets.
+func (ir *ImageResizer) renderVariants(presets map[int]Preset) (map[int]Variant, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	variants := map[int]Variant{}
+	workers := make(chan struct{}, ir.svc.renderWorkers)
+	for i, p := range presets {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, p Preset) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			img, err := ir.GetVariant(p)
+			var v Variant
+			if err == nil {
+				v, err = ir.svc.saveVariant(ir.ctx, img, ir.id, p)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			variants[i] = v
+		}(i, p)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return variants, nil
+}
+
+// Saves image of the preset named id_preset.format.
+func (s *Service) saveVariant(ctx context.Context, img image.Image, id string, p Preset) (Variant, error) {
+	v, err := s.saveImage(ctx, img, imageName(id, p.Name, p.Format), p)
 	if err != nil {
-		return "", err
+		return Variant{}, err
 	}
+	size := img.Bounds().Size()
+	v.Preset = p.Name
+	v.Width = size.X
+	v.Height = size.Y
+	return v, nil
+}
 
-	rel, err := filepath.Rel(cur, savingPath)
+// Encodes image according to the preset and puts it to the storage.
+// Returns variant with URL, size and hash of saved file.
+func (s *Service) saveImage(ctx context.Context, image image.Image, name string, p Preset) (Variant, error) {
+	var buf bytes.Buffer
+	_, end := startStage(ctx, stageEncode)
+	err := encodeImage(&buf, image, p)
+	end()
 	if err != nil {
-		return "", err
+		return Variant{}, err
 	}
 
-	f, err := os.Create(rel +"/"+ name + "." + imageFormat)
+	v := Variant{
+		Format:   p.Format,
+		Size:     int64(buf.Len()),
+		Hash:     sha256Hex(buf.Bytes()),
+		Modified: time.Now().UTC(),
+	}
+	_, end = startStage(ctx, stageSave)
+	url, err := s.storage.Save(name, &buf)
+	end()
 	if err != nil {
-		return "", err
+		return Variant{}, storageError(err)
 	}
+	v.URL = url
+	return v, nil
+}
 
-	defer f.Close()
-	switch imageFormat {
-	case JPEG:
-		opt := jpeg.Options{
-			Quality: 90,
-		}
-		err =  jpeg.Encode(f, image, &opt)
-		if err != nil {
-			return "", err
-		}
-	case PNG:
-		err =  png.Encode(f, image)
-		if err != nil {
-			return "", err
+// Deletes image with given ID, its metadata, saved variants and images
+// resized on the fly. Image disappears from index at once, if some file
+// can't be deleted, image is put back with files which are left, so
+// deleting can be retried. Original image and its copy without watermark
+// are deleted last, so image put back always can be rendered again.
+func (s *Service) DeleteImage(id string) error {
+	defer s.imageLocks.lock(id)()
+	meta, err := s.index.Get(id)
+	if os.IsNotExist(err) {
+		return err
+	}
+	if err != nil {
+		return storageError(err)
+	}
+	if err := s.index.Delete(id); err != nil {
+		return storageError(err)
+	}
+
+	variants := append([]Variant(nil), meta.Variants...)
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[j].Preset == OriginalVariant && variants[i].Preset != OriginalVariant
+	})
+	for i, v := range variants {
+		if err := s.storage.Delete(imageName(id, v.Preset, v.Format)); err != nil {
+			for _, deleted := range variants[:i] {
+				delete(meta.Presets, deleted.Preset)
+			}
+			meta.Variants = variants[i:]
+			if putErr := s.index.Put(meta); putErr != nil {
+				return storageError(fmt.Errorf("%v, image can't be restored: %v", err, putErr))
+			}
+			return storageError(err)
 		}
 	}
 
+	if meta.Source != "" {
+		if err := s.storage.Delete(meta.Source); err != nil {
+			// nothing is served any more, only the file is left
+			meta.Variants = nil
+			if putErr := s.index.Put(meta); putErr != nil {
+				return storageError(fmt.Errorf("%v, image can't be restored: %v", err, putErr))
+			}
+			return storageError(err)
+		}
+	}
 
-	return cur + "/" + rel + "/"+ name + "." + imageFormat, nil
+	cached, _ := filepath.Glob(filepath.Join(s.cacheDir, id+"_*"))
+	for _, path := range cached {
+		os.Remove(path)
+	}
+	return nil
 }
 
-func min(a,b int) int {
-	if a > b {
-		return b
+// Writes image encoded in the format and with settings of the preset.
+func encodeImage(w io.Writer, img image.Image, p Preset) error {
+	switch p.Format {
+	case JPEG:
+		if p.MaxSize > 0 {
+			return encodeJPEGWithin(w, img, p)
+		}
+		return encodeJPEG(w, img, p.jpegQuality(), p.Progressive)
+	case PNG:
+		encoder := png.Encoder{
+			CompressionLevel: p.pngCompression(),
+		}
+		if p.Optimize {
+			if paletted := reducePalette(img); paletted != nil {
+				return encoder.Encode(w, paletted)
+			}
+		}
+		return encoder.Encode(w, img)
+	case GIF:
+		if a, ok := img.(*animation); ok {
+			return gif.EncodeAll(w, a.gif())
+		}
+		return gif.Encode(w, img, nil)
+	case BMP:
+		return bmp.Encode(w, img)
+	case TIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
 	}
-	return a
-}
\ No newline at end of file
+	return fmt.Errorf("Unsupported image format %q!!!", p.Format)
+}
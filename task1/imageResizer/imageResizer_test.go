@@ -3,12 +3,16 @@ package imageResizer
 import (
 	"bytes"
 	"encoding/json"
+	"image"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -22,12 +26,17 @@ var testImages = []struct{
 	{"SmallImage", "testdata/SmallImage.jpg"},
 	{"PNGImage", "testdata/PNGImage.png"},
 	{"JPEGImage", "testdata/JPEGImage.jpeg"},
+	{"GIFImage", "testdata/GIFImage.gif"},
+	{"WEBPImage", "testdata/WEBPImage.webp"},
+	{"BMPImage", "testdata/BMPImage.bmp"},
+	{"TIFFImage", "testdata/TIFFImage.tiff"},
 }
 
 func TestImageProcessingHandler(t *testing.T) {
+	s := useTestService(t)
 	for _, tt := range testImages {
 		t.Run(tt.name, func(t *testing.T) {
-			ts := httptest.NewServer(http.HandlerFunc(ImageProcessingHandler))
+			ts := httptest.NewServer(http.HandlerFunc(s.ImageProcessingHandler))
 			defer ts.Close()
 
 			file, err := os.Open(tt.filepath)
@@ -59,14 +68,13 @@ func TestImageProcessingHandler(t *testing.T) {
 			if status := resp.StatusCode; status != http.StatusCreated {
 				t.Errorf("Got %v, but expected %v", status, http.StatusCreated)
 			}
-			if _,ok := os.Stat(relPath(result.NrmlImgPath)); os.IsNotExist(ok) {
-				t.Errorf("Normal image doesn't exist in the response path")
+			if got, want := len(result.Variants), len(s.Presets())+1; got != want {
+				t.Fatalf("Got %v variants, but expected %v", got, want)
 			}
-			if _,ok := os.Stat(relPath(result.OrigImgPath)); os.IsNotExist(ok) {
-				t.Errorf("Original image doesn't exist in the response path")
-			}
-			if _,ok := os.Stat(relPath(result.TbnlImgPath)); os.IsNotExist(ok) {
-				t.Errorf("Thumbnail image doesn't exist in the response path")
+			for _, v := range result.Variants {
+				if _, ok := os.Stat(localPath(v.URL)); os.IsNotExist(ok) {
+					t.Errorf("Image of %s variant doesn't exist in the response path", v.Preset)
+				}
 			}
 		})
 	}
@@ -84,16 +92,67 @@ func TestNewImageResizer(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			_, err = NewImageResizer(file, file.Name(), fi.Size())
+			ir, err := NewImageResizer(file, file.Name(), fi.Size())
 			if err != nil {
 				t.Fatal(err)
 			}
+			ir.Close()
 
 
 		})
 	}
 }
 
+func TestNewImageResizer_formatDetection(t *testing.T) {
+	tests := []struct {
+		name         string
+		filepath     string
+		fileName     string
+		wantFormat   string
+		wantFileName string
+		wantErr      error
+	}{
+		{"upper case extension", "testdata/JPEGImage.jpeg", "photo.JPG", JPEG, "photo", nil},
+		{"no extension", "testdata/PNGImage.png", "photo", PNG, "photo", nil},
+		{"unknown extension", "testdata/PNGImage.png", "photo.img", PNG, "photo", nil},
+		{"png named jpg", "testdata/PNGImage.png", "photo.jpg", "", "", &FormatMismatchError{Declared: JPEG, Actual: PNG}},
+		{"jpeg named png", "testdata/SmallImage.jpg", "photo.png", "", "", &FormatMismatchError{Declared: PNG, Actual: JPEG}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open(tt.filepath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			ir, err := NewImageResizer(file, tt.fileName, 0)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Fatalf("Got error %v, but expected %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ir.Close()
+			if ir.imageFormat != tt.wantFormat {
+				t.Errorf("Got format %v, but expected %v", ir.imageFormat, tt.wantFormat)
+			}
+			if ir.fileName != tt.wantFileName {
+				t.Errorf("Got file name %v, but expected %v", ir.fileName, tt.wantFileName)
+			}
+		})
+	}
+
+	t.Run("not an image", func(t *testing.T) {
+		if _, err := NewImageResizer(strings.NewReader("plain text"), "photo.jpg", 0); err == nil {
+			t.Error("Expected error for not an image")
+		}
+	})
+}
+
 func BenchmarkNewImageResizer(b *testing.B) {
 	for _, bm := range testImages {
 		b.Run(bm.name, func(b *testing.B) {
@@ -109,10 +168,11 @@ func BenchmarkNewImageResizer(b *testing.B) {
 				if err != nil {
 					b.Fatal(err)
 				}
-				_, err = NewImageResizer(file, file.Name(), fi.Size())
+				ir, err := NewImageResizer(file, file.Name(), fi.Size())
 				if err != nil {
 					b.Fatal(err)
 				}
+				ir.Close()
 			}
 		})
 	}
@@ -126,6 +186,7 @@ func BenchmarkImageResizer_GetOriginalImg(b *testing.B) {
 			if err != nil {
 				b.Fatal(err)
 			}
+			defer ir.Close()
 
 			b.ResetTimer()
 
@@ -144,6 +205,7 @@ func BenchmarkImageResizer_GetNormalImg(b *testing.B) {
 			if err != nil {
 				b.Fatal(err)
 			}
+			defer ir.Close()
 
 			b.ResetTimer()
 
@@ -152,7 +214,7 @@ func BenchmarkImageResizer_GetNormalImg(b *testing.B) {
 				if err != nil {
 					b.Fatal(err)
 				}
-				ir.normalImg = nil
+				delete(ir.variants, NormalPreset.Name)
 			}
 		})
 	}
@@ -166,6 +228,7 @@ func BenchmarkImageResizer_GetThumbnailImg(b *testing.B) {
 			if err != nil {
 				b.Fatal(err)
 			}
+			defer ir.Close()
 
 			b.ResetTimer()
 
@@ -174,14 +237,14 @@ func BenchmarkImageResizer_GetThumbnailImg(b *testing.B) {
 				if err != nil {
 					b.Fatal(err)
 				}
-				//ir.normalImg = nil
-				ir.thumbnailImg = nil
+				delete(ir.variants, ThumbnailPreset.Name)
 			}
 		})
 	}
 }
 
 func BenchmarkImageResizer_SaveImages(b *testing.B) {
+	s := useTestService(b)
 	for _, bm := range testImages {
 		b.Run(bm.name, func(b *testing.B) {
 
@@ -189,7 +252,7 @@ func BenchmarkImageResizer_SaveImages(b *testing.B) {
 			if err != nil {
 				b.Fatal(err)
 			}
-			ir.fileName = strings.ReplaceAll(ir.fileName, "/", "")
+			defer ir.Close()
 
 			_, err = ir.GetNormalImg()
 			if err != nil {
@@ -203,7 +266,9 @@ func BenchmarkImageResizer_SaveImages(b *testing.B) {
 			b.ResetTimer()
 
 			for i:=0; i < b.N; i++ {
-				_, _, _, err = ir.SaveImages()
+				// saved image is deduplicated without new index
+				s.SetIndex(&FileIndex{entries: map[string]*Metadata{}})
+				_, err = ir.SaveImages()
 				if err != nil {
 					b.Fatal(err)
 				}
@@ -212,10 +277,82 @@ func BenchmarkImageResizer_SaveImages(b *testing.B) {
 	}
 }
 
+// Renders presets from scratch with one and all render workers.
+func BenchmarkImageResizer_SavePresets(b *testing.B) {
+	s := useTestService(b)
+	presets := []Preset{
+		NormalPreset,
+		ThumbnailPreset,
+		{Name: "small", Width: 400, Height: 300, Crop: CropContain},
+		{Name: "wide", Width: 1200, Height: 400, Crop: CropTop},
+	}
+	for _, mode := range []struct {
+		name    string
+		workers int
+	}{{"sequential", 1}, {"parallel", runtime.NumCPU()}} {
+		for _, bm := range testImages {
+			b.Run(mode.name+"/"+bm.name, func(b *testing.B) {
+				s.renderWorkers = mode.workers
+				ir, err := imageResizerFromImagePath(bm.filepath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer ir.Close()
+
+				b.ResetTimer()
+
+				for i:=0; i < b.N; i++ {
+					s.SetIndex(&FileIndex{entries: map[string]*Metadata{}})
+					ir.variants = map[string]image.Image{}
+					_, err = ir.SavePresets(presets)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// Decodes and renders images by concurrent requests.
+func BenchmarkImageResizer_GetVariantParallel(b *testing.B) {
+	s := useTestService(b)
+	for _, bm := range testImages {
+		b.Run(bm.name, func(b *testing.B) {
+			data, err := ioutil.ReadFile(bm.filepath)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					// Fatal can't be called from goroutines of RunParallel
+					ir, err := NewImageResizer(bytes.NewReader(data), bm.filepath, int64(len(data)))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					for _, p := range s.Presets() {
+						if _, err := ir.GetVariant(p); err != nil {
+							ir.Close()
+							b.Error(err)
+							return
+						}
+					}
+					ir.Close()
+				}
+			})
+		})
+	}
+}
+
 func BenchmarkImageProcessingHandler(b *testing.B) {
+	s := useTestService(b)
 	for _, bm := range testImages {
 		b.Run(bm.name, func(b *testing.B) {
-			ts := httptest.NewServer(http.HandlerFunc(ImageProcessingHandler))
+			ts := httptest.NewServer(http.HandlerFunc(s.ImageProcessingHandler))
 			defer ts.Close()
 			b.StopTimer()
 			b.ResetTimer()
@@ -244,8 +381,8 @@ func BenchmarkImageProcessingHandler(b *testing.B) {
 				if err != nil {
 					b.Fatal(err)
 				}
-				if status := resp.StatusCode; status != http.StatusCreated {
-					b.Errorf("Got %v, but expected %v", status, http.StatusCreated)
+				if status := resp.StatusCode; status != http.StatusCreated && status != http.StatusOK {
+					b.Errorf("Got %v, but expected %v or %v", status, http.StatusCreated, http.StatusOK)
 				}
 			}
 		})
@@ -271,8 +408,8 @@ func imageResizerFromImagePath(path string) (*ImageResizer, error) {
 	return ir, nil
 }
 
-func relPath(path string) string{
-	cur, _ := os.Getwd()
-	rel, _ := filepath.Rel(cur, path)
-	return rel
+// Returns filesystem path from file:// URL returned by local storage.
+func localPath(rawurl string) string {
+	u, _ := url.Parse(rawurl)
+	return filepath.FromSlash(u.Path)
 }
\ No newline at end of file
@@ -0,0 +1,217 @@
+package imageResizer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Index keeps metadata of stored images by their ID.
+type Index interface {
+	// Get returns metadata of the image, error satisfies
+	// os.IsNotExist if there is no such image.
+	Get(id string) (*Metadata, error)
+	// Put adds or replaces metadata of the image.
+	Put(m *Metadata) error
+	// Delete removes metadata of the image, error satisfies
+	// os.IsNotExist if there is no such image.
+	Delete(id string) error
+	// List returns at most limit images starting from offset, newest
+	// images first, and total number of images.
+	List(offset, limit int) ([]*Metadata, int, error)
+	// Similar returns images whose perceptual hash differs from hash
+	// by at most maxDistance bits, closest images first.
+	Similar(hash uint64, maxDistance int) ([]SimilarImage, error)
+}
+
+// Sets index where metadata of saved images is kept.
+func (s *Service) SetIndex(i Index) {
+	s.index = i
+}
+
+// FileIndex keeps metadata in memory and persists it to JSON file.
+type FileIndex struct {
+	mu      sync.RWMutex
+	path    string
+	entries map[string]*Metadata
+}
+
+// Returns FileIndex loaded from file at path, the file is created
+// on first Put if it doesn't exist. Index isn't persisted if path is empty.
+func NewFileIndex(path string) (*FileIndex, error) {
+	idx := &FileIndex{
+		path:    path,
+		entries: map[string]*Metadata{},
+	}
+	if path == "" {
+		return idx, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &idx.entries); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *FileIndex) Get(id string) (*Metadata, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	m, ok := idx.entries[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return m.copy(), nil
+}
+
+func (idx *FileIndex) Put(m *Metadata) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.entries[m.ID] = m.copy()
+	return idx.save()
+}
+
+func (idx *FileIndex) Delete(id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if _, ok := idx.entries[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(idx.entries, id)
+	return idx.save()
+}
+
+func (idx *FileIndex) List(offset, limit int) ([]*Metadata, int, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	all := make([]*Metadata, 0, len(idx.entries))
+	for _, m := range idx.entries {
+		all = append(all, m)
+	}
+	// images with the same creation time are ordered by ID, so pages are stable
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].Created.Equal(all[j].Created) {
+			return all[i].Created.After(all[j].Created)
+		}
+		return all[i].ID < all[j].ID
+	})
+
+	if offset > len(all) {
+		offset = len(all)
+	}
+	end := offset + limit
+	if end > len(all) {
+		end = len(all)
+	}
+	page := make([]*Metadata, 0, end-offset)
+	for _, m := range all[offset:end] {
+		page = append(page, m.copy())
+	}
+	return page, len(all), nil
+}
+
+func (idx *FileIndex) Similar(hash uint64, maxDistance int) ([]SimilarImage, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var similar []SimilarImage
+	for _, m := range idx.entries {
+		if m.PerceptualHash == "" {
+			continue
+		}
+		h, err := parsePerceptualHash(m.PerceptualHash)
+		if err != nil {
+			continue
+		}
+		if d := hammingDistance(hash, h); d <= maxDistance {
+			similar = append(similar, SimilarImage{Metadata: m.copy(), Distance: d})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].ID < similar[j].ID
+	})
+	return similar, nil
+}
+
+// Writes entries to the file.
+func (idx *FileIndex) save() error {
+	if idx.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(idx.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(idx.path, data)
+}
+
+// Writes data to temporary file and renames it to path,
+// so the file at path is never partially written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Returns deep copy of metadata, so index entries aren't changed by callers.
+func (m *Metadata) copy() *Metadata {
+	c := *m
+	c.Variants = append([]Variant(nil), m.Variants...)
+	if m.Placeholder != nil {
+		p := *m.Placeholder
+		c.Placeholder = &p
+	}
+	c.Presets = make(map[string]Preset, len(m.Presets))
+	for name, p := range m.Presets {
+		c.Presets[name] = p
+	}
+	return &c
+}
+
+// Returns saved variant of the preset.
+func (m *Metadata) variant(preset string) Variant {
+	for _, v := range m.Variants {
+		if v.Preset == preset {
+			return v
+		}
+	}
+	return Variant{}
+}
+
+// Adds or replaces variant of the preset.
+func (m *Metadata) setVariant(p Preset, v Variant) {
+	m.Presets[p.Name] = p
+	for i := range m.Variants {
+		if m.Variants[i].Preset == p.Name {
+			m.Variants[i] = v
+			return
+		}
+	}
+	m.Variants = append(m.Variants, v)
+}
@@ -0,0 +1,292 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json")
+	idx, err := NewFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Get("a"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+
+	m := &Metadata{
+		ID:       "a",
+		Variants: []Variant{{Preset: OriginalVariant, URL: "file:///a_original.png"}},
+		Presets:  map[string]Preset{},
+	}
+	if err := idx.Put(m); err != nil {
+		t.Fatal(err)
+	}
+	m.Variants[0].URL = "changed"
+
+	// index is loaded again from the file
+	idx, err = NewFileIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := idx.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.variant(OriginalVariant).URL != "file:///a_original.png" {
+		t.Errorf("Got %+v, but expected stored metadata", got)
+	}
+}
+
+func TestFileIndex_ListDelete(t *testing.T) {
+	idx, err := NewFileIndex("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		idx.Put(&Metadata{ID: id, Created: created.Add(time.Duration(i) * time.Hour)})
+	}
+
+	tests := []struct {
+		offset, limit int
+		want          string
+	}{
+		{0, 2, "ed"},
+		{2, 2, "cb"},
+		{4, 2, "a"},
+		{10, 2, ""},
+	}
+	for _, tt := range tests {
+		page, total, err := idx.List(tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, m := range page {
+			got += m.ID
+		}
+		if got != tt.want || total != 5 {
+			t.Errorf("Got %q of %d, but expected %q of 5", got, total, tt.want)
+		}
+	}
+
+	if err := idx.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.Get("c"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+	if err := idx.Delete("c"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+}
+
+// Storage which fails to delete named objects.
+type undeletableStorage struct {
+	Storage
+	names map[string]bool
+}
+
+func (s undeletableStorage) Delete(name string) error {
+	if s.names[name] {
+		return errors.New("permission denied")
+	}
+	return s.Storage.Delete(name)
+}
+
+func TestImageHandlers(t *testing.T) {
+	s := useTestService(t)
+	local := NewLocalStorage(t.TempDir(), "")
+	s.SetStorage(local)
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/images").HandlerFunc(s.ListImagesHandler)
+	r.Methods("GET").Path("/images/{id}").HandlerFunc(s.ImageMetadataHandler)
+	r.Methods("DELETE").Path("/images/{id}").HandlerFunc(s.DeleteImageHandler)
+	r.Methods("GET").Path("/image/{id}").HandlerFunc(s.DynamicImageHandler)
+	serve := func(method, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	var ids []string
+	for _, path := range []string{"testdata/PNGImage.png", "testdata/JPEGImage.jpeg", "testdata/SmallImage.jpg"} {
+		ir, err := imageResizerFromImagePath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ir.Close()
+		if _, err := ir.SaveImages(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ir.ID())
+	}
+
+	t.Run("list", func(t *testing.T) {
+		rec := serve("GET", "/images?offset=1&limit=1")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		list := ImageList{}
+		if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != 3 || list.Offset != 1 || list.Limit != 1 || len(list.Images) != 1 {
+			t.Errorf("Got %+v, but expected second of 3 images", list)
+		}
+
+		for _, url := range []string{"/images?limit=0", "/images?limit=1000", "/images?offset=-1", "/images?offset=x"} {
+			if rec := serve("GET", url); rec.Code != http.StatusBadRequest {
+				t.Errorf("%s: got %v, but expected %v", url, rec.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		rec := serve("GET", "/images/"+ids[0])
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+		}
+		meta := Metadata{}
+		if err := json.NewDecoder(rec.Body).Decode(&meta); err != nil {
+			t.Fatal(err)
+		}
+		if meta.ID != ids[0] || meta.Format != PNG || meta.Width == 0 || len(meta.Variants) != len(s.Presets())+1 {
+			t.Errorf("Got %+v, but expected metadata of %s", meta, ids[0])
+		}
+		for _, v := range meta.Variants {
+			info, err := os.Stat(localPath(v.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Size != info.Size() {
+				t.Errorf("Got %v, but expected %v bytes of %s", v.Size, info.Size(), v.Preset)
+			}
+		}
+
+		if rec := serve("GET", "/images/missing"); rec.Code != http.StatusNotFound {
+			t.Errorf("Got %v, but expected %v", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		meta, _ := s.index.Get(ids[0])
+		if rec := serve("GET", "/image/"+ids[0]+"?w=10&h=10"); rec.Code != http.StatusOK {
+			t.Fatalf("Got %v, but expected %v", rec.Code, http.StatusOK)
+		}
+
+		if rec := serve("DELETE", "/images/"+ids[0]); rec.Code != http.StatusNoContent {
+			t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusNoContent, rec.Body)
+		}
+		for _, v := range meta.Variants {
+			if _, err := os.Stat(localPath(v.URL)); !os.IsNotExist(err) {
+				t.Errorf("Image of %s variant is not deleted", v.Preset)
+			}
+		}
+		if cached, _ := filepath.Glob(filepath.Join(s.cacheDir, ids[0]+"_*")); len(cached) != 0 {
+			t.Errorf("Got %v, but expected no cached images", cached)
+		}
+		if rec := serve("GET", "/images/"+ids[0]); rec.Code != http.StatusNotFound {
+			t.Errorf("Got %v, but expected %v", rec.Code, http.StatusNotFound)
+		}
+		if rec := serve("DELETE", "/images/"+ids[0]); rec.Code != http.StatusNotFound {
+			t.Errorf("Got %v, but expected %v", rec.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("delete failure", func(t *testing.T) {
+		s.SetStorage(undeletableStorage{
+			Storage: local,
+			names:   map[string]bool{ids[1] + "_" + ThumbnailPreset.Name + "." + JPEG: true},
+		})
+		defer s.SetStorage(local)
+
+		if rec := serve("DELETE", "/images/"+ids[1]); rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusServiceUnavailable, rec.Body)
+		}
+		// image is kept with files which are left
+		meta, err := s.index.Get(ids[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(meta.Variants) != 2 || meta.Variants[0].Preset != ThumbnailPreset.Name || meta.Variants[1].Preset != OriginalVariant {
+			t.Errorf("Got %+v, but expected thumbnail and original variants", meta.Variants)
+		}
+		if _, err := os.Stat(localPath(meta.variant(OriginalVariant).URL)); err != nil {
+			t.Errorf("Original image is lost: %v", err)
+		}
+
+		s.SetStorage(local)
+		if rec := serve("DELETE", "/images/"+ids[1]); rec.Code != http.StatusNoContent {
+			t.Errorf("Got %v, but expected %v: %s", rec.Code, http.StatusNoContent, rec.Body)
+		}
+	})
+}
+
+func TestImageProcessingHandler_deduplication(t *testing.T) {
+	s := useTestService(t)
+
+	upload := func(filepath, fileName string) (*http.Response, Result) {
+		file, err := os.Open(filepath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("image", fileName)
+		io.Copy(part, file)
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/image", body)
+		req.Header.Set("Content-type", writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		s.ImageProcessingHandler(rec, req)
+
+		result := Result{}
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Result(), result
+	}
+
+	resp, first := upload("testdata/JPEGImage.jpeg", "photo.jpg")
+	if resp.StatusCode != http.StatusCreated || first.Duplicate {
+		t.Errorf("First upload: got %v duplicate %v, but expected %v", resp.StatusCode, first.Duplicate, http.StatusCreated)
+	}
+
+	resp, again := upload("testdata/JPEGImage.jpeg", "other.jpg")
+	if resp.StatusCode != http.StatusOK || !again.Duplicate {
+		t.Errorf("Same content: got %v duplicate %v, but expected %v", resp.StatusCode, again.Duplicate, http.StatusOK)
+	}
+	if again.ID != first.ID || len(again.Variants) != len(first.Variants) {
+		t.Errorf("Same content: got %+v, but expected %+v", again, first)
+	}
+
+	resp, other := upload("testdata/SmallImage.jpg", "photo.jpg")
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Same name: got %v, but expected %v", resp.StatusCode, http.StatusCreated)
+	}
+	if other.ID == first.ID {
+		t.Error("Same name: expected other ID")
+	}
+	for _, v := range first.Variants {
+		if _, err := os.Stat(localPath(v.URL)); err != nil {
+			t.Errorf("Image of %s variant of first upload is lost: %v", v.Preset, err)
+		}
+	}
+}
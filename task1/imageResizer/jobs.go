@@ -0,0 +1,301 @@
+package imageResizer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// job statuses
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobDone       = "done"
+	JobFailed     = "failed"
+)
+
+// Job is request to render presets of saved original image in background.
+type Job struct {
+	ID      string    `json:"id"`
+	ImageID string    `json:"imageId"`
+	Presets []Preset  `json:"presets"`
+	Status  string    `json:"status"`
+	Result  *Result   `json:"result,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Enables asynchronous processing of images by the queue.
+func (s *Service) SetJobQueue(q *JobQueue) {
+	s.jobs = q
+}
+
+// JobQueue renders images in background by bounded number of workers.
+// Every job is persisted as JSON file in directory, so jobs which were
+// not finished are picked up again after restart. Finished jobs are
+// removed after retention period.
+type JobQueue struct {
+	// service images are rendered by
+	svc     *Service
+	dir     string
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    map[string]*Job
+	pending []string
+	// IDs of done and failed jobs, the oldest first
+	finished  []string
+	retention time.Duration
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Returns JobQueue persisted in dir with given number of workers
+// which render images by the service s. Done and failed jobs are
+// removed after retention, they are kept forever if it's zero.
+// Unfinished jobs found in dir are queued again.
+func NewJobQueue(s *Service, dir string, workers int, retention time.Duration) (*JobQueue, error) {
+	if workers <= 0 {
+		return nil, errors.New("Number of job workers must be positive!!!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &JobQueue{
+		svc:       s,
+		dir:       dir,
+		jobs:      map[string]*Job{},
+		retention: retention,
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var unfinished, finished []*Job
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		job := &Job{}
+		if err := json.Unmarshal(data, job); err != nil {
+			return nil, err
+		}
+		q.jobs[job.ID] = job
+		if job.Status == JobQueued || job.Status == JobProcessing {
+			unfinished = append(unfinished, job)
+		} else {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(unfinished, func(i, j int) bool {
+		return unfinished[i].Created.Before(unfinished[j].Created)
+	})
+	for _, job := range unfinished {
+		job.Status = JobQueued
+		q.pending = append(q.pending, job.ID)
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Updated.Before(finished[j].Updated)
+	})
+	for _, job := range finished {
+		q.finished = append(q.finished, job.ID)
+	}
+	q.removeExpired()
+
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q, nil
+}
+
+// Queues rendering of presets of saved image with given ID.
+func (q *JobQueue) Submit(imageID string, presets []Preset) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	job := &Job{
+		ID:      hex.EncodeToString(id),
+		ImageID: imageID,
+		Presets: presets,
+		Status:  JobQueued,
+		Created: now,
+		Updated: now,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.removeExpired()
+	if err := q.persist(job); err != nil {
+		return nil, err
+	}
+	q.jobs[job.ID] = job
+	q.pending = append(q.pending, job.ID)
+	q.cond.Signal()
+
+	return job.copy(), nil
+}
+
+// Returns job by ID, error satisfies os.IsNotExist if there is no such job.
+func (q *JobQueue) Get(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return job.copy(), nil
+}
+
+// Stops workers after jobs they are processing now are finished.
+// Queued jobs stay persisted and are processed after restart.
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// Same as Close, but returns error of ctx if it's done before
+// workers are stopped, they are left to finish in background.
+func (q *JobQueue) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		q.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.pending) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[q.pending[0]]
+		q.pending = q.pending[1:]
+		q.update(job, JobProcessing, nil, nil)
+		imageID, presets := job.ImageID, job.Presets
+		q.mu.Unlock()
+
+		result, err := q.svc.renderJob(imageID, presets)
+
+		q.mu.Lock()
+		if err != nil {
+			_, code := errorStatus(err)
+			countError(code)
+			q.update(job, JobFailed, nil, err)
+		} else {
+			q.update(job, JobDone, result, nil)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Renders presets of saved image, job is traced by its own trace,
+// because request it was submitted by is already finished.
+func (s *Service) renderJob(imageID string, presets []Preset) (result *Result, err error) {
+	ctx, span := tracer.Start(context.Background(), "job", trace.WithAttributes(attribute.String("image.id", imageID)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	ir, err := s.loadImageResizer(ctx, imageID)
+	if err != nil {
+		return nil, err
+	}
+	defer ir.Close()
+	return ir.SavePresets(presets)
+}
+
+// Sets status of the job and persists it, q.mu must be held.
+func (q *JobQueue) update(job *Job, status string, result *Result, err error) {
+	job.Status = status
+	job.Result = result
+	job.Error = ""
+	if err != nil {
+		job.Error = err.Error()
+	}
+	job.Updated = time.Now().UTC()
+	if status == JobDone || status == JobFailed {
+		q.finished = append(q.finished, job.ID)
+	}
+	// job is still available in memory, it's only lost on restart
+	if err := q.persist(job); err != nil {
+		log.Printf("Job %s can't be persisted: %v", job.ID, err)
+	}
+}
+
+// Removes finished jobs older than retention period, q.mu must be held.
+func (q *JobQueue) removeExpired() {
+	if q.retention <= 0 {
+		return
+	}
+	expired := time.Now().Add(-q.retention)
+	for len(q.finished) > 0 {
+		job := q.jobs[q.finished[0]]
+		if job.Updated.After(expired) {
+			return
+		}
+		if err := os.Remove(filepath.Join(q.dir, job.ID+".json")); err != nil && !os.IsNotExist(err) {
+			// it's tried again with the next job
+			log.Printf("Job %s can't be removed: %v", job.ID, err)
+			return
+		}
+		delete(q.jobs, job.ID)
+		q.finished = q.finished[1:]
+	}
+}
+
+func (q *JobQueue) persist(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(q.dir, job.ID+".json"), data)
+}
+
+func (j *Job) copy() *Job {
+	c := *j
+	c.Presets = append([]Preset(nil), j.Presets...)
+	return &c
+}
@@ -0,0 +1,266 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Waits until job is finished.
+func waitJob(t *testing.T, q *JobQueue, id string) *Job {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := q.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == JobDone || job.Status == JobFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s is not finished", id)
+	return nil
+}
+
+func saveOriginal(t *testing.T, filepath, fileName string) *ImageResizer {
+	file, err := os.Open(filepath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	ir, err := NewImageResizer(file, fileName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if err := ir.SaveOriginal(); err != nil {
+		t.Fatal(err)
+	}
+	return ir
+}
+
+func TestJobQueue(t *testing.T) {
+	s := useTestService(t)
+
+	q, err := NewJobQueue(s, t.TempDir(), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ir := saveOriginal(t, "testdata/PNGImage.png", "image.png")
+	job, err := q.Submit(ir.ID(), []Preset{ThumbnailPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobQueued {
+		t.Errorf("Got %v, but expected %v", job.Status, JobQueued)
+	}
+
+	job = waitJob(t, q, job.ID)
+	if job.Status != JobDone {
+		t.Fatalf("Got %v (%s), but expected %v", job.Status, job.Error, JobDone)
+	}
+	if job.Result.ID != ir.ID() || len(job.Result.Variants) != 2 {
+		t.Errorf("Got %+v, but expected original and thumbnail of %s", job.Result, ir.ID())
+	}
+
+	// image which was never saved
+	job, err = q.Submit("unknown", []Preset{ThumbnailPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitJob(t, q, job.ID)
+	if job.Status != JobFailed || job.Error == "" {
+		t.Errorf("Got %v (%s), but expected %v", job.Status, job.Error, JobFailed)
+	}
+
+	if _, err := q.Get("missing"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+}
+
+func TestJobQueue_restart(t *testing.T) {
+	s := useTestService(t)
+	dir := t.TempDir()
+
+	// closed queue only persists jobs
+	q, err := NewJobQueue(s, dir, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	ir := saveOriginal(t, "testdata/JPEGImage.jpeg", "image.jpeg")
+	job, err := q.Submit(ir.ID(), []Preset{NormalPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err = NewJobQueue(s, dir, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if job = waitJob(t, q, job.ID); job.Status != JobDone {
+		t.Errorf("Got %v (%s), but expected %v", job.Status, job.Error, JobDone)
+	}
+}
+
+func TestNewJobQueue_noWorkers(t *testing.T) {
+	s := useTestService(t)
+	if _, err := NewJobQueue(s, t.TempDir(), 0, 0); err == nil {
+		t.Errorf("Got no error, but expected error")
+	}
+}
+
+func TestJobQueue_retention(t *testing.T) {
+	s := useTestService(t)
+	dir := t.TempDir()
+	q, err := NewJobQueue(s, dir, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	ir := saveOriginal(t, "testdata/PNGImage.png", "image.png")
+	old, err := q.Submit(ir.ID(), []Preset{ThumbnailPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, q, old.ID)
+	recent, err := q.Submit(ir.ID(), []Preset{ThumbnailPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, q, recent.ID)
+
+	// the first job was finished long ago
+	q.mu.Lock()
+	q.jobs[old.ID].Updated = time.Now().Add(-2 * time.Hour)
+	q.mu.Unlock()
+	if _, err := q.Submit(ir.ID(), []Preset{ThumbnailPreset}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Get(old.ID); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old.ID+".json")); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected file of the job to be removed", err)
+	}
+	if _, err := q.Get(recent.ID); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewJobQueue_retention(t *testing.T) {
+	s := useTestService(t)
+	dir := t.TempDir()
+	q, err := NewJobQueue(s, dir, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ir := saveOriginal(t, "testdata/PNGImage.png", "image.png")
+	job, err := q.Submit(ir.ID(), []Preset{ThumbnailPreset})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, q, job.ID)
+	// job was finished two days ago
+	q.mu.Lock()
+	q.jobs[job.ID].Updated = time.Now().Add(-48 * time.Hour)
+	q.persist(q.jobs[job.ID])
+	q.mu.Unlock()
+	q.Close()
+
+	// configured retention applies to jobs found on start
+	q, err = NewJobQueue(s, dir, 1, 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if _, err := q.Get(job.ID); err != nil {
+		t.Errorf("Got %v, but expected job to be kept for a week", err)
+	}
+
+	q, err = NewJobQueue(s, dir, 1, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+	if _, err := q.Get(job.ID); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, job.ID+".json")); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected file of the job to be removed", err)
+	}
+}
+
+func TestImageProcessingHandler_async(t *testing.T) {
+	s := useTestService(t)
+	q, err := NewJobQueue(s, t.TempDir(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	s.SetJobQueue(q)
+
+	r := mux.NewRouter()
+	r.Methods("POST").Path("/image").HandlerFunc(s.ImageProcessingHandler)
+	r.Methods("GET").Path("/image/jobs/{id}").HandlerFunc(s.JobStatusHandler)
+
+	file, err := os.Open("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("async", "true")
+	part, _ := writer.CreateFormFile("image", "image.png")
+	io.Copy(part, file)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/image", body)
+	req.Header.Set("Content-type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Got %v (%s), but expected %v", rec.Code, rec.Body, http.StatusAccepted)
+	}
+	job := Job{}
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if location := rec.Header().Get("Location"); location != "/image/jobs/"+job.ID {
+		t.Errorf("Got %v, but expected /image/jobs/%s", location, job.ID)
+	}
+
+	waitJob(t, q, job.ID)
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/image/jobs/"+job.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v", rec.Code, http.StatusOK)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobDone || len(job.Result.Variants) != len(s.Presets())+1 {
+		t.Errorf("Got %+v, but expected finished job", job)
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/image/jobs/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Got %v, but expected %v", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,89 @@
+package imageResizer
+
+import (
+	"fmt"
+	"io"
+)
+
+// Sets maximum image file size in megabytes and maximum number of
+// pixels of decoded image (sum over all frames of animated GIF).
+func (s *Service) SetLimits(maxSize, maxPixels int64) {
+	s.maxImageSize = maxSize
+	s.maxImagePixels = maxPixels
+}
+
+// TooLargeError is returned when image file exceeds maximum file size.
+type TooLargeError struct {
+	// Maximum file size in megabytes
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("Image too large!!! Maximum file size %d MB.", e.Limit)
+}
+
+func (e *TooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// TooManyPixelsError is returned when decoded image would have
+// more pixels than allowed.
+type TooManyPixelsError struct {
+	Width, Height int
+	Frames        int
+	Limit         int64
+}
+
+func (e *TooManyPixelsError) Error() string {
+	if e.Frames > 1 {
+		return fmt.Sprintf("Image too large!!! %d frames of %dx%d exceed %d pixels.", e.Frames, e.Width, e.Height, e.Limit)
+	}
+	return fmt.Sprintf("Image too large!!! %dx%d exceeds %d pixels.", e.Width, e.Height, e.Limit)
+}
+
+func (e *TooManyPixelsError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// Returns TooManyPixelsError if frames of width x height exceed limit.
+func checkPixels(width, height, frames int, limit int64) error {
+	if int64(width)*int64(height)*int64(frames) > limit {
+		return &TooManyPixelsError{Width: width, Height: height, Frames: frames, Limit: limit}
+	}
+	return nil
+}
+
+// sizeLimitedReader reads from r and fails with TooLargeError
+// as soon as more than n bytes are available.
+type sizeLimitedReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func newSizeLimitedReader(r io.Reader, limitMB int64) *sizeLimitedReader {
+	return &sizeLimitedReader{r: r, n: limitMB * 1024 * 1024, limit: limitMB}
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		// limit reached, it's fine only if nothing left
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, &TooLargeError{Limit: l.limit}
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	return n, err
+}
+
+// Returns number of bytes read.
+func (l *sizeLimitedReader) read() int64 {
+	return l.limit*1024*1024 - l.n
+}
@@ -0,0 +1,139 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// Returns tiny PNG which declares width x height in its header.
+func pngBomb(t testing.TB, width, height uint32) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	// IHDR chunk data starts after 8 bytes signature, 4 bytes length and 4 bytes type
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestNewImageResizer_limits(t *testing.T) {
+	s := useTestService(t)
+	s.SetLimits(2, 1000*1000)
+
+	t.Run("declared size", func(t *testing.T) {
+		_, err := NewImageResizer(bytes.NewReader(pngBomb(t, 1, 1)), "a.png", 3*1024*1024)
+		if _, ok := err.(*TooLargeError); !ok {
+			t.Errorf("Got %v, but expected TooLargeError", err)
+		}
+	})
+
+	t.Run("actual size", func(t *testing.T) {
+		s.SetLimits(2, 10*1000*1000)
+		file, err := os.Open("testdata/BigImage.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		// size is not declared, so it has to be checked while reading
+		_, err = NewImageResizer(file, "BigImage.jpg", 0)
+		if _, ok := err.(*TooLargeError); !ok {
+			t.Errorf("Got %v, but expected TooLargeError", err)
+		}
+	})
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		s.SetLimits(2, 1000*1000)
+		_, err := NewImageResizer(bytes.NewReader(pngBomb(t, 50000, 50000)), "bomb.png", 0)
+		if _, ok := err.(*TooManyPixelsError); !ok {
+			t.Errorf("Got %v, but expected TooManyPixelsError", err)
+		}
+	})
+
+	t.Run("animation frames", func(t *testing.T) {
+		s.SetLimits(2, 160*120*2)
+		_, err := imageResizerFromImagePath("testdata/GIFImage.gif")
+		if _, ok := err.(*TooManyPixelsError); !ok {
+			t.Errorf("Got %v, but expected TooManyPixelsError", err)
+		}
+	})
+
+	t.Run("animation bomb", func(t *testing.T) {
+		// 20 frames of 2000x2000 take 80MB decoded, file takes a few kilobytes
+		s.SetLimits(2, 10*1000*1000)
+		frame := image.NewPaletted(image.Rect(0, 0, 2000, 2000), color.Palette{color.Black, color.White})
+		g := &gif.GIF{}
+		for i := 0; i < 20; i++ {
+			g.Image = append(g.Image, frame)
+			g.Delay = append(g.Delay, 10)
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := NewImageResizer(bytes.NewReader(buf.Bytes()), "bomb.gif", 0)
+		runtime.ReadMemStats(&after)
+		if _, ok := err.(*TooManyPixelsError); !ok {
+			t.Errorf("Got %v, but expected TooManyPixelsError", err)
+		}
+		// frames are rejected before they are decoded
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4*1024*1024 {
+			t.Errorf("Got %v bytes allocated, but expected at most %v", allocated, 4*1024*1024)
+		}
+	})
+}
+
+func TestImageProcessingHandler_limits(t *testing.T) {
+	s := useTestService(t)
+	s.SetLimits(2, 1000*1000)
+
+	big, err := os.Open("testdata/BigImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer big.Close()
+
+	tests := []struct {
+		name       string
+		data       io.Reader
+		wantStatus int
+	}{
+		{"too large", big, http.StatusRequestEntityTooLarge},
+		{"decompression bomb", bytes.NewReader(pngBomb(t, 50000, 50000)), http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &bytes.Buffer{}
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("image", "image")
+			io.Copy(part, tt.data)
+			writer.Close()
+
+			req := httptest.NewRequest("POST", "/image", body)
+			req.Header.Set("Content-type", writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.ImageProcessingHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Got %v, but expected %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package imageResizer
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"net/http"
+	"time"
+)
+
+// stages of image processing, they are labels of metrics and names of spans
+const (
+	stageDecode = "decode"
+	stageCrop   = "crop"
+	stageResize = "resize"
+	stageEncode = "encode"
+	stageSave   = "save"
+)
+
+// Metrics are shared by all services of the process and served by
+// Handler at /metrics with metrics of Go runtime.
+var (
+	stageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "imageresizer",
+		Name:      "stage_duration_seconds",
+		Help:      "Time taken by stages of image processing: decode, crop, resize, encode and save.",
+		// from 1ms to 16s
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"stage"})
+	stagesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "imageresizer",
+		Name:      "stages_in_flight",
+		Help:      "Number of images being processed by stage.",
+	}, []string{"stage"})
+	imagesDecoded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "imageresizer",
+		Name:      "images_decoded_total",
+		Help:      "Number of decoded images by their format.",
+	}, []string{"format"})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "imageresizer",
+		Name:      "errors_total",
+		Help:      "Number of errors returned to clients by their code.",
+	}, []string{"type"})
+	requestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "imageresizer",
+		Name:      "requests_in_flight",
+		Help:      "Number of HTTP requests being served.",
+	})
+)
+
+// Starts span of the stage of image processing as child of ctx and
+// measures how long the stage takes. Returned function ends it.
+func startStage(ctx context.Context, stage string) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, stage)
+	inFlight := stagesInFlight.WithLabelValues(stage)
+	inFlight.Inc()
+	start := time.Now()
+	return ctx, func() {
+		stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+		inFlight.Dec()
+		span.End()
+	}
+}
+
+// Counts error of the code, e.g. CodeDecode.
+func countError(code string) {
+	errorsTotal.WithLabelValues(code).Inc()
+}
+
+// Middleware which counts requests being served.
+func countInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestsInFlight.Inc()
+		defer requestsInFlight.Dec()
+		next.ServeHTTP(w, r)
+	})
+}
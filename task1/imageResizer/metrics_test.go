@@ -0,0 +1,99 @@
+package imageResizer
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Uploads file to POST /image of the handler with given headers.
+func postImage(t *testing.T, handler http.Handler, name string, data []byte, header http.Header) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("image", name)
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/image", body)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// Returns number of measured stages.
+func stageCount(t *testing.T, stage string) uint64 {
+	m := &dto.Metric{}
+	if err := stageDuration.WithLabelValues(stage).(prometheus.Histogram).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	s := useTestService(t)
+	handler := s.Handler()
+
+	stages := map[string]uint64{}
+	for _, stage := range []string{stageDecode, stageCrop, stageResize, stageEncode, stageSave} {
+		stages[stage] = stageCount(t, stage)
+	}
+	decoded := testutil.ToFloat64(imagesDecoded.WithLabelValues(JPEG))
+	unsupported := testutil.ToFloat64(errorsTotal.WithLabelValues(CodeUnsupportedFormat))
+
+	if rec := postImage(t, handler, "image.jpeg", readTestFile(t, "testdata/JPEGImage.jpeg"), nil); rec.Code != http.StatusCreated {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if rec := postImage(t, handler, "notes.txt", []byte("not an image"), nil); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusUnsupportedMediaType, rec.Body)
+	}
+
+	// normal and thumbnail presets are cropped and resized,
+	// they are saved with original image
+	for stage, want := range map[string]uint64{
+		stageDecode: 1,
+		stageCrop:   2,
+		stageResize: 2,
+		stageEncode: 3,
+		stageSave:   3,
+	} {
+		if got := stageCount(t, stage) - stages[stage]; got != want {
+			t.Errorf("%s: got %v, but expected %v", stage, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(imagesDecoded.WithLabelValues(JPEG)) - decoded; got != 1 {
+		t.Errorf("Got %v decoded images, but expected %v", got, 1)
+	}
+	if got := testutil.ToFloat64(errorsTotal.WithLabelValues(CodeUnsupportedFormat)) - unsupported; got != 1 {
+		t.Errorf("Got %v errors, but expected %v", got, 1)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v", rec.Code, http.StatusOK)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	for _, want := range []string{
+		`imageresizer_stage_duration_seconds_bucket{stage="decode",le="0.001"}`,
+		`imageresizer_images_decoded_total{format="jpeg"}`,
+		`imageresizer_errors_total{type="unsupported_format"}`,
+		`imageresizer_stages_in_flight{stage="resize"} 0`,
+		// request for metrics itself
+		`imageresizer_requests_in_flight 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Got no %s in metrics", want)
+		}
+	}
+}
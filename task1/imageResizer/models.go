@@ -1,17 +1,151 @@
 package imageResizer
 
-import "image"
+import (
+	"context"
+	"image"
+	"sync"
+	"time"
+)
 
 type ImageResizer struct {
-	originalImg  image.Image
-	normalImg    image.Image
-	thumbnailImg image.Image
-	imageFormat  string
-	fileName     string
+	// service image is saved by
+	svc *Service
+	// context spans of processing stages are children of
+	ctx         context.Context
+	originalImg image.Image
+	// resized images by preset name, guarded by mu
+	mu          sync.Mutex
+	variants    map[string]image.Image
+	imageFormat string
+	fileName    string
+	// hash of uploaded file
+	id       string
+	fileSize int64
+	// Exif metadata of original image, nil if there is none
+	exif []byte
+	// image is loaded from the storage, so it isn't saved again
+	// if it's deleted meanwhile
+	loaded bool
+	// memory budget taken by decoded image until Close, guarded by mu
+	budget *memorySemaphore
+	taken  int64
+}
+
+// Preset describes one resized variant of the image.
+type Preset struct {
+	Name   string `json:"name"`
+	Width  uint   `json:"width"`
+	Height uint   `json:"height"`
+	// One of Crop* constants, CropCenter if empty.
+	Crop string `json:"crop,omitempty"`
+	// Focal point of CropFocal as "x,y" fractions of image size, "0.5,0.5" if empty.
+	Focus string `json:"focus,omitempty"`
+	// Background color of CropContain: "#rrggbb", "#rrggbbaa" or "transparent", white if empty.
+	Background string `json:"background,omitempty"`
+	// Output image format, format of original image if empty.
+	Format string `json:"format,omitempty"`
+	// One of Filter* constants, FilterLanczos3 if empty.
+	Filter string `json:"filter,omitempty"`
+	// JPEG quality from 1 to 100, 90 if zero.
+	Quality int `json:"quality,omitempty"`
+	// Maximum size of JPEG image in bytes, quality is lowered until image
+	// fits. There is no limit if zero. Without Format it applies to images
+	// which are saved as JPEG, other formats have no limit.
+	MaxSize int64 `json:"maxSize,omitempty"`
+	// Save JPEG image as progressive.
+	Progressive bool `json:"progressive,omitempty"`
+	// PNG compression level: default, none, fast or best.
+	Compression string `json:"compression,omitempty"`
+	// Save PNG image with no more than 256 colors as paletted one.
+	// Without Format it applies to images which are saved as PNG.
+	Optimize bool `json:"optimize,omitempty"`
+	// Stamp watermark set by SetWatermark.
+	Watermark bool `json:"watermark,omitempty"`
+}
+
+// Variant is saved image of one preset.
+type Variant struct {
+	Preset string `json:"preset"`
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format"`
+	// Size of saved file in bytes
+	Size int64 `json:"size"`
+	// SHA-256 of saved file, hex encoded
+	Hash     string    `json:"hash"`
+	Modified time.Time `json:"modified"`
 }
 
 type Result struct {
-	NrmlImgPath string `json:"nrmlImgPath"`
-	OrigImgPath string `json:"origImgPath"`
-	TbnlImgPath string `json:"tbnlImgPath"`
+	// ID of the image to request it resized on the fly
+	ID       string    `json:"id"`
+	Variants []Variant `json:"variants"`
+	// Image with the same content was already uploaded and nothing was rendered
+	Duplicate bool `json:"duplicate"`
+	// What to show while image is loading
+	Placeholder *Placeholder `json:"placeholder,omitempty"`
+}
+
+// Placeholder is shown by clients instead of the image while it's loading.
+type Placeholder struct {
+	// BlurHash of the image, see https://blurha.sh
+	BlurHash string `json:"blurHash"`
+	// Dominant color of the image as "#rrggbb"
+	Color string `json:"color"`
+	// Tiny blurry copy of the image as JPEG data URL
+	LQIP string `json:"lqip"`
+}
+
+// Metadata describes stored image and all its saved variants.
+type Metadata struct {
+	ID string `json:"id"`
+	// Name of uploaded file without extension
+	FileName string `json:"fileName"`
+	// Format of uploaded image
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Size of uploaded file in bytes
+	Size     int64     `json:"size"`
+	Created  time.Time `json:"created"`
+	Variants []Variant `json:"variants"`
+	// Settings variants were rendered with by preset name
+	Presets map[string]Preset `json:"presets"`
+	// Name of original image without watermark in the storage,
+	// empty if original isn't stamped
+	Source string `json:"source,omitempty"`
+	// Placeholder of the image, nil if it was saved before they were made
+	Placeholder *Placeholder `json:"placeholder,omitempty"`
+	// Perceptual hash of the image as 16 hex digits, empty if
+	// it was saved before they were made
+	PerceptualHash string `json:"perceptualHash,omitempty"`
+}
+
+// ImageList is page of stored images.
+type ImageList struct {
+	Images []*Metadata `json:"images"`
+	// Number of all stored images
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+// SimilarImage is stored image which looks like another one.
+type SimilarImage struct {
+	*Metadata
+	// Hamming distance of perceptual hashes, 0 if images look the same
+	Distance int `json:"distance"`
+}
+
+// SimilarImageList is images similar to the requested one, closest first.
+type SimilarImageList struct {
+	Images []SimilarImage `json:"images"`
+}
+
+// ErrorResponse is body of failed API request.
+type ErrorResponse struct {
+	// One of Code* constants
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
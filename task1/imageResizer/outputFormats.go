@@ -0,0 +1,46 @@
+package imageResizer
+
+import (
+	"fmt"
+)
+
+// formats images can be saved in
+var encodableFormats = []string{JPEG, PNG, GIF, BMP, TIFF}
+
+// Sets formats images are saved in by format of uploaded image,
+// e.g. {"webp": "png", "bmp": "png"}. Formats which can't be encoded
+// have to be converted.
+func (s *Service) SetOutputFormats(formats map[string]string) error {
+	for input, output := range formats {
+		if !decodableFormats[input] {
+			return fmt.Errorf("Unsupported image format %q!!!", input)
+		}
+		if !isEncodable(output) {
+			return fmt.Errorf("Images can't be saved as %q!!!", output)
+		}
+	}
+	for input := range decodableFormats {
+		if _, ok := formats[input]; !ok && !isEncodable(input) {
+			return fmt.Errorf("Output format for %q images required!!!", input)
+		}
+	}
+	s.outputFormats = formats
+	return nil
+}
+
+// Returns format image of the given format is saved in.
+func (s *Service) outputFormat(input string) string {
+	if output, ok := s.outputFormats[input]; ok {
+		return output
+	}
+	return input
+}
+
+func isEncodable(format string) bool {
+	for _, f := range encodableFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
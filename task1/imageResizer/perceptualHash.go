@@ -0,0 +1,55 @@
+package imageResizer
+
+import (
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"math/bits"
+	"strconv"
+)
+
+const (
+	// Hamming distance of similar images found by default and at most
+	defaultSimilarDistance = 10
+	maxSimilarDistance     = 64
+)
+
+// Returns difference hash (dHash) of the image: image is shrunk to 9x8
+// grayscale pixels and every bit tells whether pixel is brighter than
+// its right neighbour. Resized, re-encoded or slightly edited copies
+// of the image get the same or close hash.
+func perceptualHash(img image.Image) uint64 {
+	if a, ok := img.(*animation); ok {
+		img = a.Image
+	}
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	b := small.Bounds()
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := color.GrayModel.Convert(small.At(b.Min.X+x, b.Min.Y+y)).(color.Gray)
+			right := color.GrayModel.Convert(small.At(b.Min.X+x+1, b.Min.Y+y)).(color.Gray)
+			hash <<= 1
+			if left.Y > right.Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Returns perceptual hash as it's kept in metadata.
+func formatPerceptualHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+func parsePerceptualHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// Returns number of bits which differ in two hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,170 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Returns image resizer of the image shrunk to width and saved as JPEG
+// of low quality.
+func reencoded(t *testing.T, path string, width uint) *ImageResizer {
+	ir, err := imageResizerFromImagePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resize.Resize(width, 0, ir.originalImg, resize.Bilinear), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewImageResizer(bytes.NewReader(buf.Bytes()), "copy.jpg", int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c
+}
+
+func TestPerceptualHash(t *testing.T) {
+	medium, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer medium.Close()
+	png, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer png.Close()
+	hash := perceptualHash(medium.originalImg)
+
+	tests := []struct {
+		name    string
+		img     image.Image
+		similar bool
+	}{
+		{"resized copy", reencoded(t, "testdata/MediumImage.jpg", 300).originalImg, true},
+		{"other image", png.originalImg, false},
+		{"uniform image", uniform(100, 100, color.Gray{128}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := hammingDistance(hash, perceptualHash(tt.img))
+			if similar := d <= defaultSimilarDistance; similar != tt.similar {
+				t.Errorf("Got distance %v, but expected similar to be %v", d, tt.similar)
+			}
+		})
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xff, 0x0f, 4},
+		{0, ^uint64(0), 64},
+	}
+	for _, tt := range tests {
+		if got := hammingDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Got %v, but expected %v", got, tt.want)
+		}
+	}
+
+	hash, err := parsePerceptualHash(formatPerceptualHash(0x0123456789abcdef))
+	if err != nil || hash != 0x0123456789abcdef {
+		t.Errorf("Got %x, %v, but expected 0123456789abcdef", hash, err)
+	}
+}
+
+func TestFileIndex_Similar(t *testing.T) {
+	idx := &FileIndex{entries: map[string]*Metadata{}}
+	idx.Put(&Metadata{ID: "b", PerceptualHash: formatPerceptualHash(0x3)})
+	idx.Put(&Metadata{ID: "a", PerceptualHash: formatPerceptualHash(0x1)})
+	idx.Put(&Metadata{ID: "c", PerceptualHash: formatPerceptualHash(0x1)})
+	idx.Put(&Metadata{ID: "far", PerceptualHash: formatPerceptualHash(0xff)})
+	idx.Put(&Metadata{ID: "old"})
+
+	similar, err := idx.Similar(0x1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, image := range similar {
+		got = append(got, image.ID)
+	}
+	if want := "a c b"; len(similar) != 3 || similar[2].Distance != 1 ||
+		got[0]+" "+got[1]+" "+got[2] != want {
+		t.Errorf("Got %v, but expected %v", got, want)
+	}
+}
+
+func TestSimilarImagesHandler(t *testing.T) {
+	s := useTestService(t)
+	handler := s.Handler()
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		return rec
+	}
+
+	var ids []string
+	for _, ir := range []*ImageResizer{
+		reencoded(t, "testdata/MediumImage.jpg", 500),
+		reencoded(t, "testdata/MediumImage.jpg", 200),
+		reencoded(t, "testdata/PNGImage.png", 300),
+	} {
+		if _, err := ir.SaveImages(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ir.ID())
+	}
+
+	rec := serve("/images/" + ids[0] + "/similar")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	list := SimilarImageList{}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Images) != 1 || list.Images[0].ID != ids[1] {
+		t.Errorf("Got %+v, but expected only %v", list.Images, ids[1])
+	}
+
+	// every image is within maximum distance
+	list = SimilarImageList{}
+	json.NewDecoder(serve("/images/" + ids[0] + "/similar?distance=64").Body).Decode(&list)
+	if len(list.Images) != 2 {
+		t.Errorf("Got %v images, but expected 2", len(list.Images))
+	}
+
+	// image saved before hashes were kept
+	meta, _ := s.index.Get(ids[1])
+	meta.PerceptualHash = ""
+	s.index.Put(meta)
+	if rec := serve("/images/" + ids[1] + "/similar"); rec.Code != http.StatusOK {
+		t.Errorf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if meta, _ := s.index.Get(ids[1]); meta.PerceptualHash == "" {
+		t.Error("Got no perceptual hash in metadata")
+	}
+
+	for url, code := range map[string]int{
+		"/images/" + ids[0] + "/similar?distance=65": http.StatusBadRequest,
+		"/images/" + ids[0] + "/similar?distance=x":  http.StatusBadRequest,
+		"/images/missing/similar":                    http.StatusNotFound,
+	} {
+		if rec := serve(url); rec.Code != code {
+			t.Errorf("%s: got %v, but expected %v", url, rec.Code, code)
+		}
+	}
+}
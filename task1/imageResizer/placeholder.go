@@ -0,0 +1,215 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/nfnt/resize"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"strings"
+)
+
+const (
+	// Image is downscaled to fit this size before BlurHash and
+	// dominant color are computed, details don't matter for them
+	placeholderSampleSize = 32
+	// Number of BlurHash components along longer side of the image
+	blurHashComponents = 4
+	// Size of longer side and quality of LQIP image
+	lqipSize    = 16
+	lqipQuality = 50
+)
+
+// Returns placeholder shown by clients while the image is loading:
+// BlurHash, dominant color and tiny JPEG image.
+func (ir *ImageResizer) Placeholder() (*Placeholder, error) {
+	img := ir.originalImg
+	if a, ok := img.(*animation); ok {
+		img = a.Image
+	}
+	sample := resize.Thumbnail(placeholderSampleSize, placeholderSampleSize, img, resize.Bilinear)
+
+	lqip, err := lqipDataURL(img)
+	if err != nil {
+		return nil, err
+	}
+	return &Placeholder{
+		BlurHash: blurHash(sample),
+		Color:    dominantColor(sample),
+		LQIP:     lqip,
+	}, nil
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Returns BlurHash of the image, see https://github.com/woltapp/blurhash.
+// Number of components follows aspect ratio of the image.
+func blurHash(img image.Image) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	nx, ny := blurHashComponents, blurHashComponents
+	if width > height {
+		ny = clampInt(int(math.Round(float64(blurHashComponents*height)/float64(width))), 1, blurHashComponents)
+	} else {
+		nx = clampInt(int(math.Round(float64(blurHashComponents*width)/float64(height))), 1, blurHashComponents)
+	}
+
+	// pixels in linear RGB
+	pixels := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			pixels[y*width+x] = [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, nx*ny)
+	for j := 0; j < ny; j++ {
+		for i := 0; i < nx; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i*x)/float64(width)) *
+						math.Cos(math.Pi*float64(j*y)/float64(height))
+					p := pixels[y*width+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((nx-1)+(ny-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			actualMax = math.Max(actualMax, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMax := clampInt(int(math.Floor(actualMax*166-0.5)), 0, 82)
+		maxValue = float64(quantisedMax+1) / 166
+		hash.WriteString(base83(quantisedMax, 1))
+	} else {
+		hash.WriteString(base83(0, 1))
+	}
+
+	hash.WriteString(base83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+	for _, f := range ac {
+		quantise := func(v float64) int {
+			return clampInt(int(math.Floor(signPow(v/maxValue, 0.5)*9+9.5)), 0, 18)
+		}
+		hash.WriteString(base83(quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2))
+	}
+	return hash.String()
+}
+
+func base83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = base83Chars[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Returns the most common color of the image as "#rrggbb". Similar colors
+// are counted together, transparent pixels are ignored unless there
+// are only them.
+func dominantColor(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	var all bucket
+	var best *bucket
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			all.count++
+			all.r, all.g, all.b = all.r+int(c.R), all.g+int(c.G), all.b+int(c.B)
+			if c.A < 128 {
+				continue
+			}
+			// 4 bits of every channel
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{}
+				buckets[key] = b
+			}
+			b.count++
+			b.r, b.g, b.b = b.r+int(c.R), b.g+int(c.G), b.b+int(c.B)
+			if best == nil || b.count > best.count {
+				best = b
+			}
+		}
+	}
+	if best == nil {
+		best = &all
+	}
+	if best.count == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+// Returns tiny blurry copy of the image as JPEG data URL,
+// transparent areas are white.
+func lqipDataURL(img image.Image) (string, error) {
+	small := resize.Thumbnail(lqipSize, lqipSize, img, resize.Bilinear)
+	canvas := image.NewRGBA(small.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), small, small.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: lqipQuality}); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
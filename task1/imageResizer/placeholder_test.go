@@ -0,0 +1,130 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+func uniform(width, height int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	return img
+}
+
+func TestBlurHash(t *testing.T) {
+	tests := []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		// 4x3 components of black image, reference hash of woltapp/blurhash
+		{"black", uniform(32, 24, color.Black), "L00000fQfQfQfQfQfQfQfQfQfQfQ"},
+		// 4x1 components
+		{"wide", uniform(32, 4, color.Black), "300000fQfQfQ"},
+		// 1x4 components
+		{"tall", uniform(4, 32, color.Black), "R00000fQfQfQ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blurHash(tt.img); got != tt.want {
+				t.Errorf("Got %v, but expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDominantColor(t *testing.T) {
+	mostlyRed := uniform(10, 10, color.RGBA{200, 0, 0, 255})
+	draw.Draw(mostlyRed, image.Rect(0, 0, 10, 3), image.NewUniform(color.RGBA{0, 0, 200, 255}), image.Point{}, draw.Src)
+	redOnTransparent := uniform(10, 10, color.Transparent)
+	draw.Draw(redOnTransparent, image.Rect(0, 0, 2, 2), image.NewUniform(color.RGBA{200, 0, 0, 255}), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name string
+		img  image.Image
+		want string
+	}{
+		{"uniform", uniform(4, 4, color.RGBA{0x12, 0x34, 0x56, 255}), "#123456"},
+		{"mostly red", mostlyRed, "#c80000"},
+		{"transparent ignored", redOnTransparent, "#c80000"},
+		{"transparent", uniform(4, 4, color.Transparent), "#000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dominantColor(tt.img); got != tt.want {
+				t.Errorf("Got %v, but expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImageResizer_Placeholder(t *testing.T) {
+	s := useTestService(t)
+
+	for _, tt := range testImages {
+		t.Run(tt.name, func(t *testing.T) {
+			ir, err := imageResizerFromImagePath(tt.filepath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ir.Close()
+			result, err := ir.SavePresets([]Preset{ThumbnailPreset})
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := result.Placeholder
+			if p == nil {
+				t.Fatal("Got no placeholder")
+			}
+			if len(p.BlurHash) < 6 || len(p.Color) != 7 || p.Color[0] != '#' {
+				t.Errorf("Got %+v, but expected BlurHash and color", p)
+			}
+
+			data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(p.LQIP, "data:image/jpeg;base64,"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			lqip, err := jpeg.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := lqip.Bounds().Size(); size.X > lqipSize || size.Y > lqipSize {
+				t.Errorf("Got %v, but expected at most %vx%v", size, lqipSize, lqipSize)
+			}
+
+			meta, err := s.index.Get(ir.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if meta.Placeholder == nil || *meta.Placeholder != *p {
+				t.Errorf("Got %+v in metadata, but expected %+v", meta.Placeholder, p)
+			}
+		})
+	}
+
+	// image saved before placeholders were made
+	ir, err := imageResizerFromImagePath("testdata/SmallImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if err := ir.SaveOriginal(); err != nil {
+		t.Fatal(err)
+	}
+	meta, _ := s.index.Get(ir.ID())
+	meta.Placeholder = nil
+	s.index.Put(meta)
+	result, err := ir.SavePresets(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta, _ := s.index.Get(ir.ID()); meta.Placeholder == nil || result.Placeholder == nil || !result.Duplicate {
+		t.Errorf("Got %+v, but expected placeholder of duplicate image", result)
+	}
+}
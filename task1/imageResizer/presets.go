@@ -0,0 +1,255 @@
+package imageResizer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nfnt/resize"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+)
+
+// crop modes
+const (
+	// Crops the biggest centered area of preset's aspect ratio and
+	// resizes it to exact preset size.
+	CropCenter = "center"
+	// Same as CropCenter, but area is anchored to the top of image.
+	CropTop = "top"
+	// Same as CropCenter, but area is centered at focal point of preset.
+	CropFocal = "focal"
+	// Same as CropCenter, but area with the most details is chosen.
+	CropEntropy = "entropy"
+	// Resizes whole image to fit into preset size keeping aspect ratio.
+	CropNone = "none"
+	// Stretches whole image to exact preset size ignoring aspect ratio.
+	CropFill = "fill"
+	// Resizes whole image to fit into preset size keeping aspect ratio
+	// and pads it with background color of preset to exact preset size.
+	CropContain = "contain"
+)
+
+// resampling filters
+const (
+	FilterNearest  = "nearest"
+	FilterBilinear = "bilinear"
+	FilterBicubic  = "bicubic"
+	FilterLanczos3 = "lanczos3"
+)
+
+var filters = map[string]resize.InterpolationFunction{
+	FilterNearest:  resize.NearestNeighbor,
+	FilterBilinear: resize.Bilinear,
+	FilterBicubic:  resize.Bicubic,
+	FilterLanczos3: resize.Lanczos3,
+}
+
+// PNG compression levels
+var compressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"fast":    png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// JPEG quality used if preset doesn't set it
+const defaultQuality = 90
+
+// Name of the variant with original image.
+const OriginalVariant = "original"
+
+// Name of original image without watermark, it's never served.
+const SourceVariant = "source"
+
+var (
+	// Preset of normal image
+	NormalPreset = Preset{Name: "normal", Width: 800, Height: 800, Crop: CropCenter, Watermark: true}
+	// Preset of thumbnail image
+	ThumbnailPreset = Preset{Name: "thumbnail", Width: 200, Height: 200, Crop: CropCenter}
+)
+
+// Sets presets which will be rendered by SaveImages.
+func (s *Service) SetPresets(p []Preset) error {
+	if len(p) == 0 {
+		return errors.New("At least one preset required!!!")
+	}
+	// "similar" is path of SimilarImagesHandler next to variants
+	names := map[string]bool{OriginalVariant: true, SourceVariant: true, "similar": true}
+	for _, preset := range p {
+		if err := preset.validate(); err != nil {
+			return err
+		}
+		if names[preset.Name] {
+			return fmt.Errorf("Duplicate preset name %q!!!", preset.Name)
+		}
+		names[preset.Name] = true
+	}
+	s.presets = p
+	return nil
+}
+
+// Returns presets rendered by SaveImages.
+func (s *Service) Presets() []Preset {
+	return s.presets
+}
+
+// Reads presets from JSON config file of the form
+// {"presets": [{"name": "avatar", "width": 128, "height": 128, "crop": "center", "format": "png"}]}
+func LoadPresets(path string) ([]Preset, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Presets []Preset `json:"presets"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config.Presets, nil
+}
+
+func (p Preset) validate() error {
+	if p.Name == "" {
+		return errors.New("Preset name required!!!")
+	}
+	if p.Width == 0 || p.Height == 0 {
+		return fmt.Errorf("Preset %q: width and height required!!!", p.Name)
+	}
+	switch p.Crop {
+	case "", CropCenter, CropTop, CropFocal, CropEntropy, CropNone, CropFill, CropContain:
+	default:
+		return fmt.Errorf("Preset %q: unknown crop mode %q!!!", p.Name, p.Crop)
+	}
+	if _, _, err := parseFocus(p.Focus); err != nil {
+		return fmt.Errorf("Preset %q: %v", p.Name, err)
+	}
+	if _, err := parseColor(p.Background); err != nil {
+		return fmt.Errorf("Preset %q: %v", p.Name, err)
+	}
+	if p.Format != "" && !isEncodable(p.Format) {
+		return fmt.Errorf("Preset %q: unsupported format %q!!!", p.Name, p.Format)
+	}
+	if _, ok := filters[p.Filter]; p.Filter != "" && !ok {
+		return fmt.Errorf("Preset %q: unknown filter %q!!!", p.Name, p.Filter)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("Preset %q: quality must be between 1 and 100!!!", p.Name)
+	}
+	if p.MaxSize < 0 {
+		return fmt.Errorf("Preset %q: maxSize can't be negative!!!", p.Name)
+	}
+	// without format they apply to uploads which are saved in that format
+	if p.MaxSize > 0 && p.Format != "" && p.Format != JPEG {
+		return fmt.Errorf("Preset %q: maxSize requires jpeg format!!!", p.Name)
+	}
+	if p.Optimize && p.Format != "" && p.Format != PNG {
+		return fmt.Errorf("Preset %q: optimize requires png format!!!", p.Name)
+	}
+	if _, ok := compressionLevels[p.Compression]; p.Compression != "" && !ok {
+		return fmt.Errorf("Preset %q: unknown compression %q!!!", p.Name, p.Compression)
+	}
+	return nil
+}
+
+// Returns background color of the preset, white by default.
+func (p Preset) backgroundColor() color.Color {
+	c, err := parseColor(p.Background)
+	if err != nil {
+		return color.White
+	}
+	return c
+}
+
+// Returns resampling filter of the preset, Lanczos3 by default.
+func (p Preset) interpolation() resize.InterpolationFunction {
+	if filter, ok := filters[p.Filter]; ok {
+		return filter
+	}
+	return resize.Lanczos3
+}
+
+// Returns JPEG quality of the preset.
+func (p Preset) jpegQuality() int {
+	if p.Quality == 0 {
+		return defaultQuality
+	}
+	return p.Quality
+}
+
+// Returns PNG compression level of the preset.
+func (p Preset) pngCompression() png.CompressionLevel {
+	return compressionLevels[p.Compression]
+}
+
+// Returns presets with crop and encoding settings overridden by form values.
+// Value of "crop", "focus", "background", "filter", "quality", "compression",
+// "maxSize" and "progressive" applies to every preset, value prefixed with
+// preset name, e.g. "thumbnail.filter", applies to that preset only.
+// Variants are shared by everyone who uploads the same image, so changed
+// preset gets its own name, e.g. "thumbnail-1f2e3d4c", and is saved next
+// to variant of the configured preset instead of replacing it.
+func overridePresets(presets []Preset, form url.Values) ([]Preset, error) {
+	result := make([]Preset, len(presets))
+	for i, p := range presets {
+		for _, prefix := range []string{"", p.Name + "."} {
+			if crop := form.Get(prefix + "crop"); crop != "" {
+				p.Crop = crop
+			}
+			if focus := form.Get(prefix + "focus"); focus != "" {
+				p.Focus = focus
+			}
+			if background := form.Get(prefix + "background"); background != "" {
+				p.Background = background
+			}
+			if filter := form.Get(prefix + "filter"); filter != "" {
+				p.Filter = filter
+			}
+			if quality := form.Get(prefix + "quality"); quality != "" {
+				q, err := strconv.Atoi(quality)
+				if err != nil || q < 1 {
+					return nil, fmt.Errorf("Preset %q: quality must be between 1 and 100!!!", p.Name)
+				}
+				p.Quality = q
+			}
+			if compression := form.Get(prefix + "compression"); compression != "" {
+				p.Compression = compression
+			}
+			if maxSize := form.Get(prefix + "maxSize"); maxSize != "" {
+				size, err := strconv.ParseInt(maxSize, 10, 64)
+				if err != nil || size < 0 {
+					return nil, fmt.Errorf("Preset %q: maxSize must be number of bytes!!!", p.Name)
+				}
+				p.MaxSize = size
+			}
+			if progressive := form.Get(prefix + "progressive"); progressive != "" {
+				prog, err := strconv.ParseBool(progressive)
+				if err != nil {
+					return nil, fmt.Errorf("Preset %q: progressive must be true or false!!!", p.Name)
+				}
+				p.Progressive = prog
+			}
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if p != presets[i] {
+			p.Name = overriddenName(p)
+		}
+		result[i] = p
+	}
+	return result, nil
+}
+
+// Returns name of preset with overridden settings: name of the preset
+// and hash of its settings, so the same settings always get the same name.
+func overriddenName(p Preset) string {
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return p.Name + "-" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,248 @@
+package imageResizer
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadPresets(t *testing.T) {
+	p, err := LoadPresets("testdata/presets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 4 {
+		t.Fatalf("Got %v presets, but expected %v", len(p), 4)
+	}
+	if p[1] != (Preset{Name: "card", Width: 400, Height: 300, Crop: CropCenter, Format: JPEG, Filter: FilterBicubic, Quality: 80}) {
+		t.Errorf("Unexpected preset %+v", p[1])
+	}
+}
+
+func TestSetPresets(t *testing.T) {
+	s := newService()
+
+	tests := []struct {
+		name    string
+		presets []Preset
+		wantErr bool
+	}{
+		{"valid", []Preset{{Name: "a", Width: 1, Height: 1}}, false},
+		{"empty", nil, true},
+		{"no name", []Preset{{Width: 1, Height: 1}}, true},
+		{"no size", []Preset{{Name: "a", Width: 1}}, true},
+		{"duplicate", []Preset{{Name: "a", Width: 1, Height: 1}, {Name: "a", Width: 2, Height: 2}}, true},
+		{"original", []Preset{{Name: OriginalVariant, Width: 1, Height: 1}}, true},
+		{"unknown crop", []Preset{{Name: "a", Width: 1, Height: 1, Crop: "diagonal"}}, true},
+		{"unknown format", []Preset{{Name: "a", Width: 1, Height: 1, Format: "webp"}}, true},
+		{"unknown filter", []Preset{{Name: "a", Width: 1, Height: 1, Filter: "box"}}, true},
+		{"quality too high", []Preset{{Name: "a", Width: 1, Height: 1, Quality: 101}}, true},
+		{"negative maxSize", []Preset{{Name: "a", Width: 1, Height: 1, MaxSize: -1}}, true},
+		{"maxSize", []Preset{{Name: "a", Width: 1, Height: 1, Format: JPEG, MaxSize: 1000}}, false},
+		{"maxSize of png", []Preset{{Name: "a", Width: 1, Height: 1, Format: PNG, MaxSize: 1000}}, true},
+		{"maxSize of original format", []Preset{{Name: "a", Width: 1, Height: 1, MaxSize: 1000}}, false},
+		{"optimize", []Preset{{Name: "a", Width: 1, Height: 1, Format: PNG, Optimize: true}}, false},
+		{"optimize of jpeg", []Preset{{Name: "a", Width: 1, Height: 1, Format: JPEG, Optimize: true}}, true},
+		{"unknown compression", []Preset{{Name: "a", Width: 1, Height: 1, Compression: "max"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.SetPresets(tt.presets); (err != nil) != tt.wantErr {
+				t.Errorf("Got error %v, but expected error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImageResizer_SaveImages_presets(t *testing.T) {
+	s := useTestService(t)
+
+	p, err := LoadPresets("testdata/presets.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPresets(p); err != nil {
+		t.Fatal(err)
+	}
+
+	ir, err := imageResizerFromImagePath("testdata/MediumImage.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	result, err := ir.SaveImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	variants := result.Variants
+
+	want := []Variant{
+		{Preset: OriginalVariant, Format: JPEG},
+		{Preset: "avatar", Width: 128, Height: 128, Format: PNG},
+		{Preset: "card", Width: 400, Height: 300, Format: JPEG},
+		{Preset: "hero", Width: 1600, Height: 600, Format: JPEG},
+		{Preset: "thumb", Format: JPEG},
+	}
+	if len(variants) != len(want) {
+		t.Fatalf("Got %v variants, but expected %v", len(variants), len(want))
+	}
+	for i, v := range variants {
+		if v.Preset != want[i].Preset || v.Format != want[i].Format {
+			t.Errorf("Got %s.%s, but expected %s.%s", v.Preset, v.Format, want[i].Preset, want[i].Format)
+		}
+		if want[i].Width != 0 && (v.Width != want[i].Width || v.Height != want[i].Height) {
+			t.Errorf("%s: got %vx%v, but expected %vx%v", v.Preset, v.Width, v.Height, want[i].Width, want[i].Height)
+		}
+	}
+	if thumb := variants[4]; thumb.Width > 200 || thumb.Height > 200 {
+		t.Errorf("thumb: got %vx%v, expected to fit into 200x200", thumb.Width, thumb.Height)
+	}
+}
+
+func TestOverridePresets(t *testing.T) {
+	presets := []Preset{
+		{Name: "normal", Width: 800, Height: 800, Quality: 85},
+		{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG},
+	}
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    []Preset
+		wantErr bool
+	}{
+		{
+			"no overrides",
+			url.Values{},
+			presets,
+			false,
+		},
+		{
+			"every preset",
+			url.Values{"filter": {"bilinear"}, "quality": {"70"}},
+			[]Preset{
+				{Name: "normal", Width: 800, Height: 800, Filter: FilterBilinear, Quality: 70},
+				{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG, Filter: FilterBilinear, Quality: 70},
+			},
+			false,
+		},
+		{
+			"single preset",
+			url.Values{"quality": {"70"}, "thumbnail.quality": {"50"}, "thumbnail.compression": {"best"}},
+			[]Preset{
+				{Name: "normal", Width: 800, Height: 800, Quality: 70},
+				{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG, Quality: 50, Compression: "best"},
+			},
+			false,
+		},
+		{
+			"crop",
+			url.Values{"crop": {"focal"}, "focus": {"0.2,0.3"}, "thumbnail.crop": {"contain"}, "thumbnail.background": {"#000"}},
+			[]Preset{
+				{Name: "normal", Width: 800, Height: 800, Quality: 85, Crop: CropFocal, Focus: "0.2,0.3"},
+				{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG, Crop: CropContain, Focus: "0.2,0.3", Background: "#000"},
+			},
+			false,
+		},
+		{
+			// watermark is set by configuration only
+			"watermark",
+			url.Values{"watermark": {"true"}, "thumbnail.watermark": {"true"}},
+			presets,
+			false,
+		},
+		{
+			"size budget",
+			url.Values{"thumbnail.maxSize": {"20000"}, "progressive": {"true"}},
+			[]Preset{
+				{Name: "normal", Width: 800, Height: 800, Quality: 85, Progressive: true},
+				{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG, MaxSize: 20000, Progressive: true},
+			},
+			false,
+		},
+		{"invalid maxSize", url.Values{"maxSize": {"20KB"}}, nil, true},
+		{
+			// normal preset is limited if upload is saved as JPEG
+			"size budget of original format",
+			url.Values{"maxSize": {"20000"}},
+			[]Preset{
+				{Name: "normal", Width: 800, Height: 800, Quality: 85, MaxSize: 20000},
+				{Name: "thumbnail", Width: 200, Height: 200, Format: JPEG, MaxSize: 20000},
+			},
+			false,
+		},
+		{"invalid progressive", url.Values{"progressive": {"maybe"}}, nil, true},
+		{"unknown crop", url.Values{"crop": {"smart"}}, nil, true},
+		{"invalid focus", url.Values{"focus": {"2,0"}}, nil, true},
+		{"invalid background", url.Values{"background": {"red"}}, nil, true},
+		{"unknown filter", url.Values{"filter": {"box"}}, nil, true},
+		{"invalid quality", url.Values{"normal.quality": {"high"}}, nil, true},
+		{"quality out of range", url.Values{"quality": {"0"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := overridePresets(presets, tt.form)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Got error %v, but expected error %v", err, tt.wantErr)
+			}
+			for i := range tt.want {
+				// changed preset is renamed
+				p := got[i]
+				if p != presets[i] {
+					if !strings.HasPrefix(p.Name, presets[i].Name+"-") {
+						t.Errorf("Got name %q, but expected name of %q with hash", p.Name, presets[i].Name)
+					}
+					p.Name = presets[i].Name
+				}
+				if p != tt.want[i] {
+					t.Errorf("Got %+v, but expected %+v", p, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestImageResizer_SavePresets_overridden(t *testing.T) {
+	s := useTestService(t)
+	ir, err := imageResizerFromImagePath("testdata/JPEGImage.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	shared, err := ir.SavePresets(s.Presets())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same image uploaded by someone else with other settings
+	presets, err := overridePresets(s.Presets(), url.Values{"quality": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	overridden, err := ir.SavePresets(presets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := overridePresets(s.Presets(), url.Values{"quality": {"1"}})
+	if again[0].Name != presets[0].Name {
+		t.Errorf("Got %q, but expected the same name %q", again[0].Name, presets[0].Name)
+	}
+
+	meta, err := s.index.Get(ir.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range shared.Variants[1:] {
+		if got := meta.variant(v.Preset); got.Hash != v.Hash {
+			t.Errorf("%s: shared variant was replaced", v.Preset)
+		}
+		o := overridden.Variants[i+1]
+		if o.Preset == v.Preset || o.Hash == v.Hash {
+			t.Errorf("Got %s variant %v, but expected other one than %s", o.Preset, o.Hash, v.Preset)
+		}
+		if _, err := os.Stat(localPath(o.URL)); err != nil {
+			t.Error(err)
+		}
+	}
+}
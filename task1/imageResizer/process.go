@@ -0,0 +1,178 @@
+package imageResizer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProcessOptions describes bulk processing of image directory.
+type ProcessOptions struct {
+	// Directory with images, it's walked recursively
+	In string
+	// Directory where variants are written, tree of In is kept
+	Out string
+	// Presets rendered for every image
+	Presets []Preset
+	// Number of images processed at once, 1 if zero
+	Workers int
+	// Render images which are up to date too
+	Force bool
+	// Where failures are reported, nothing is reported if nil
+	Log io.Writer
+}
+
+// ProcessSummary is result of ProcessDir.
+type ProcessSummary struct {
+	Processed int
+	Skipped   int
+	Failed    int
+	Duration  time.Duration
+}
+
+func (s ProcessSummary) String() string {
+	return fmt.Sprintf("Processed %d, skipped %d, failed %d images in %v",
+		s.Processed, s.Skipped, s.Failed, s.Duration.Round(time.Millisecond))
+}
+
+// Renders presets of every image found in directory tree opt.In and writes
+// them to the same place in opt.Out as name_preset.format. Images whose
+// variants are newer than image itself are skipped. Failure of one image
+// doesn't stop processing, it's reported to opt.Log and counted.
+func (s *Service) ProcessDir(opt ProcessOptions) (ProcessSummary, error) {
+	start := time.Now()
+	var files []string
+	out, _ := filepath.Abs(opt.Out)
+	err := filepath.Walk(opt.In, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// output inside of input isn't processed again
+			if abs, _ := filepath.Abs(path); abs == out {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := extensionFormats[strings.ToLower(filepath.Ext(path))]; ok {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return ProcessSummary{}, err
+	}
+
+	workers := opt.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		summary ProcessSummary
+	)
+	paths := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				processed, err := s.processFile(opt, path)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					summary.Failed++
+					if opt.Log != nil {
+						fmt.Fprintf(opt.Log, "%s: %v\n", path, err)
+					}
+				case processed:
+					summary.Processed++
+				default:
+					summary.Skipped++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range files {
+		paths <- path
+	}
+	close(paths)
+	wg.Wait()
+
+	summary.Duration = time.Since(start)
+	return summary, nil
+}
+
+// Renders presets of the image unless they are up to date.
+// Returns false if image was skipped.
+func (s *Service) processFile(opt ProcessOptions, path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(opt.In, path)
+	if err != nil {
+		return false, err
+	}
+	ext := filepath.Ext(rel)
+	base := filepath.Join(opt.Out, strings.TrimSuffix(rel, ext))
+	format := s.outputFormat(extensionFormats[strings.ToLower(ext)])
+
+	presets := make([]Preset, len(opt.Presets))
+	outputs := make([]string, len(opt.Presets))
+	upToDate := !opt.Force
+	for i, p := range opt.Presets {
+		if p.Format == "" {
+			p.Format = format
+		}
+		presets[i] = p
+		outputs[i] = base + "_" + p.Name + "." + p.Format
+		if out, err := os.Stat(outputs[i]); err != nil || out.ModTime().Before(info.ModTime()) {
+			upToDate = false
+		}
+	}
+	if upToDate {
+		return false, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	ir, err := s.NewImageResizer(file, filepath.Base(path), info.Size())
+	if err != nil {
+		return false, err
+	}
+	defer ir.Close()
+
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return false, err
+	}
+	for i, p := range presets {
+		img, err := ir.GetVariant(p)
+		if err != nil {
+			return false, err
+		}
+		var buf bytes.Buffer
+		if err := encodeImage(&buf, img, p); err != nil {
+			return false, err
+		}
+		if err := writeFileAtomic(outputs[i], buf.Bytes()); err != nil {
+			return false, err
+		}
+		// temporary files are readable only by owner
+		if err := os.Chmod(outputs[i], 0644); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
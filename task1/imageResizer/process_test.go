@@ -0,0 +1,71 @@
+package imageResizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessDir(t *testing.T) {
+	in := t.TempDir()
+	// output inside of input must not be processed as input
+	out := filepath.Join(in, "resized")
+	files := map[string]string{
+		"a.png":            "testdata/PNGImage.png",
+		"photos/b.jpeg":    "testdata/JPEGImage.jpeg",
+		"photos/old/c.bmp": "testdata/BMPImage.bmp",
+	}
+	for name, src := range files {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(in, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(in, "broken.jpg"), []byte("not an image"), 0644)
+	ioutil.WriteFile(filepath.Join(in, "notes.txt"), []byte("not an image"), 0644)
+
+	opt := ProcessOptions{
+		In:      in,
+		Out:     out,
+		Presets: []Preset{NormalPreset, ThumbnailPreset},
+		Workers: 2,
+	}
+	check := func(name string, want ProcessSummary) {
+		got, err := newService().ProcessDir(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Duration = 0
+		if got != want {
+			t.Errorf("%s: got %+v, but expected %+v", name, got, want)
+		}
+	}
+
+	check("first run", ProcessSummary{Processed: 3, Failed: 1})
+	for _, name := range []string{"a_normal.png", "a_thumbnail.png", "photos/b_normal.jpeg", "photos/old/c_thumbnail.bmp"} {
+		if _, err := os.Stat(filepath.Join(out, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Image %s isn't written: %v", name, err)
+		}
+	}
+
+	check("up to date", ProcessSummary{Skipped: 3, Failed: 1})
+
+	// changed image is rendered again
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(in, "a.png"), future, future)
+	check("changed", ProcessSummary{Processed: 1, Skipped: 2, Failed: 1})
+
+	// new preset isn't rendered yet
+	opt.Presets = append(opt.Presets, Preset{Name: "small", Width: 50, Height: 50})
+	check("new preset", ProcessSummary{Processed: 3, Failed: 1})
+
+	opt.Force = true
+	check("force", ProcessSummary{Processed: 3, Failed: 1})
+}
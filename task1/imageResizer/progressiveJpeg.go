@@ -0,0 +1,485 @@
+package imageResizer
+
+import (
+	"bufio"
+	"image"
+	"io"
+	"math"
+)
+
+// image/jpeg writes baseline JPEG only. Progressive JPEG is shown blurry
+// at first and sharpens while it's loading, and it's usually a bit smaller.
+// Encoder below uses the same quantization and Huffman tables as image/jpeg
+// and 4:2:0 chroma subsampling, so images look the same.
+
+// Quantization tables of section K.1 of the JPEG spec in natural order.
+var unscaledQuant = [2][64]int{
+	// luminance
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	// chrominance
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// Natural index of coefficients in zig-zag order.
+var zigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+type huffmanSpec struct {
+	// number of codes of each length from 1 to 16 bits
+	counts [16]byte
+	values []byte
+}
+
+// Huffman tables of section K.3 of the JPEG spec: luminance DC,
+// luminance AC, chrominance DC and chrominance AC.
+var huffmanSpecs = [4]huffmanSpec{
+	{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// Huffman code of a value: code in low bits and its length.
+type huffmanCode struct {
+	code   uint32
+	length uint
+}
+
+// Returns codes of values of the table, see section C of the JPEG spec.
+func (s huffmanSpec) codes() [256]huffmanCode {
+	var codes [256]huffmanCode
+	code, k := uint32(0), 0
+	for length := uint(1); length <= 16; length++ {
+		for i := 0; i < int(s.counts[length-1]); i++ {
+			codes[s.values[k]] = huffmanCode{code, length}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// Progressive scans: component, first and last coefficient in
+// zig-zag order. DC of all components goes first, then low frequencies
+// of luminance, then color and remaining details of luminance.
+var progressiveScans = []struct {
+	component int
+	start     int
+	end       int
+}{
+	{-1, 0, 0},
+	{0, 1, 5},
+	{2, 1, 63},
+	{1, 1, 63},
+	{0, 6, 63},
+}
+
+// 8x8 block of quantized DCT coefficients in natural order
+type block [64]int32
+
+// Writes image as progressive JPEG of the quality from 1 to 100.
+// Alpha channel is dropped like image/jpeg does.
+func encodeProgressiveJPEG(w io.Writer, img image.Image, quality int) error {
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 || b.Dx() > 0xFFFF || b.Dy() > 0xFFFF {
+		return jpegSizeError(b.Size())
+	}
+	quant := scaledQuant(quality)
+	blocks := jpegBlocks(img, quant)
+
+	e := &jpegEncoder{w: bufio.NewWriter(w)}
+	e.write([]byte{0xFF, 0xD8})
+	e.writeDQT(quant)
+	e.writeSOF2(b.Dx(), b.Dy())
+	e.writeDHT()
+	for _, scan := range progressiveScans {
+		e.writeScan(blocks, b.Dx(), b.Dy(), scan.component, scan.start, scan.end)
+	}
+	e.write([]byte{0xFF, 0xD9})
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+type jpegSizeError image.Point
+
+func (e jpegSizeError) Error() string {
+	return "Image of " + image.Point(e).String() + " can't be saved as JPEG!!!"
+}
+
+// Returns quantization tables scaled the same way as by image/jpeg.
+func scaledQuant(quality int) [2][64]int {
+	quality = clampInt(quality, 1, 100)
+	scale := 200 - quality*2
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+	var quant [2][64]int
+	for t := range unscaledQuant {
+		for i, q := range unscaledQuant[t] {
+			quant[t][i] = clampInt((q*scale+50)/100, 1, 255)
+		}
+	}
+	return quant
+}
+
+// Returns quantized blocks of Y, Cb and Cr components. Image is padded
+// to whole 16x16 MCUs by repeating edge pixels, chroma is subsampled 2x2.
+func jpegBlocks(img image.Image, quant [2][64]int) [3][]block {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	mcuX, mcuY := (width+15)/16, (height+15)/16
+
+	// YCbCr of padded image, decoded JPEG is YCbCr already and it's taken
+	// as it is, because converting it to RGB and back loses colors
+	pw, ph := mcuX*16, mcuY*16
+	planes := [3][]float64{make([]float64, pw*ph), make([]float64, pw*ph), make([]float64, pw*ph)}
+	ycbcr, _ := img.(*image.YCbCr)
+	for y := 0; y < ph; y++ {
+		for x := 0; x < pw; x++ {
+			px, py := b.Min.X+clampInt(x, 0, width-1), b.Min.Y+clampInt(y, 0, height-1)
+			i := y*pw + x
+			if ycbcr != nil {
+				c := ycbcr.YCbCrAt(px, py)
+				planes[0][i] = float64(c.Y) - 128
+				planes[1][i] = float64(c.Cb) - 128
+				planes[2][i] = float64(c.Cr) - 128
+				continue
+			}
+			r, g, bl, _ := img.At(px, py).RGBA()
+			fr, fg, fb := float64(r>>8), float64(g>>8), float64(bl>>8)
+			planes[0][i] = 0.299*fr + 0.587*fg + 0.114*fb - 128
+			planes[1][i] = -0.168736*fr - 0.331264*fg + 0.5*fb
+			planes[2][i] = 0.5*fr - 0.418688*fg - 0.081312*fb
+		}
+	}
+
+	var blocks [3][]block
+	blocks[0] = make([]block, mcuX*2*mcuY*2)
+	for by := 0; by < mcuY*2; by++ {
+		for bx := 0; bx < mcuX*2; bx++ {
+			var pixels [64]float64
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					pixels[y*8+x] = planes[0][(by*8+y)*pw+bx*8+x]
+				}
+			}
+			blocks[0][by*mcuX*2+bx] = quantize(fdct(pixels), quant[0])
+		}
+	}
+	for c := 1; c < 3; c++ {
+		blocks[c] = make([]block, mcuX*mcuY)
+		for by := 0; by < mcuY; by++ {
+			for bx := 0; bx < mcuX; bx++ {
+				var pixels [64]float64
+				for y := 0; y < 8; y++ {
+					for x := 0; x < 8; x++ {
+						i := (by*16+y*2)*pw + bx*16 + x*2
+						pixels[y*8+x] = (planes[c][i] + planes[c][i+1] + planes[c][i+pw] + planes[c][i+pw+1]) / 4
+					}
+				}
+				blocks[c][by*mcuX+bx] = quantize(fdct(pixels), quant[1])
+			}
+		}
+	}
+	return blocks
+}
+
+var dctCos = func() (table [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			table[x][u] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / 16)
+		}
+	}
+	return table
+}()
+
+// Returns 2D DCT of the block, rows and then columns.
+func fdct(pixels [64]float64) [64]float64 {
+	var rows, result [64]float64
+	for y := 0; y < 8; y++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for x := 0; x < 8; x++ {
+				sum += pixels[y*8+x] * dctCos[x][u]
+			}
+			rows[y*8+u] = sum
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				sum += rows[y*8+u] * dctCos[y][v]
+			}
+			cu, cv := 1.0, 1.0
+			if u == 0 {
+				cu = math.Sqrt2 / 2
+			}
+			if v == 0 {
+				cv = math.Sqrt2 / 2
+			}
+			result[v*8+u] = sum * cu * cv / 4
+		}
+	}
+	return result
+}
+
+func quantize(coefficients [64]float64, quant [64]int) block {
+	var b block
+	for i, c := range coefficients {
+		b[i] = int32(math.Round(c / float64(quant[i])))
+	}
+	return b
+}
+
+type jpegEncoder struct {
+	w   *bufio.Writer
+	err error
+	// bits which aren't written yet, in low nbits bits
+	bits  uint32
+	nbits uint
+}
+
+func (e *jpegEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *jpegEncoder) writeMarker(marker byte, data []byte) {
+	n := len(data) + 2
+	e.write([]byte{0xFF, marker, byte(n >> 8), byte(n)})
+	e.write(data)
+}
+
+func (e *jpegEncoder) writeDQT(quant [2][64]int) {
+	data := make([]byte, 0, 2*65)
+	for t := range quant {
+		data = append(data, byte(t))
+		for _, i := range zigzag {
+			data = append(data, byte(quant[t][i]))
+		}
+	}
+	e.writeMarker(0xDB, data)
+}
+
+func (e *jpegEncoder) writeSOF2(width, height int) {
+	e.writeMarker(0xC2, []byte{
+		8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), 3,
+		// id, sampling factors and quantization table of Y, Cb and Cr
+		1, 0x22, 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+}
+
+func (e *jpegEncoder) writeDHT() {
+	var data []byte
+	for i, s := range huffmanSpecs {
+		// class is 0 for DC and 1 for AC, id is 0 for luminance and 1 for chrominance
+		data = append(data, byte(i%2)<<4|byte(i/2))
+		data = append(data, s.counts[:]...)
+		data = append(data, s.values...)
+	}
+	e.writeMarker(0xC4, data)
+}
+
+// Writes scan of coefficients from start to end of the component,
+// or DC of all components if component is -1.
+func (e *jpegEncoder) writeScan(blocks [3][]block, width, height, component, start, end int) {
+	if component < 0 {
+		e.writeMarker(0xDA, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 0, 0})
+	} else {
+		tables := byte(0x00)
+		if component > 0 {
+			tables = 0x11
+		}
+		e.writeMarker(0xDA, []byte{1, byte(component + 1), tables, byte(start), byte(end), 0})
+	}
+
+	mcuX, mcuY := (width+15)/16, (height+15)/16
+	dcCodes, chromaDCCodes := huffmanSpecs[0].codes(), huffmanSpecs[2].codes()
+	if component < 0 {
+		var prev [3]int32
+		dc := func(c int, b block) {
+			codes := dcCodes
+			if c > 0 {
+				codes = chromaDCCodes
+			}
+			e.writeValue(codes, 0, b[0]-prev[c])
+			prev[c] = b[0]
+		}
+		// interleaved in MCUs of 4 luminance and 2 chroma blocks
+		for my := 0; my < mcuY; my++ {
+			for mx := 0; mx < mcuX; mx++ {
+				for i := 0; i < 4; i++ {
+					dc(0, blocks[0][(my*2+i/2)*mcuX*2+mx*2+i%2])
+				}
+				dc(1, blocks[1][my*mcuX+mx])
+				dc(2, blocks[2][my*mcuX+mx])
+			}
+		}
+		e.flushBits()
+		return
+	}
+
+	codes := huffmanSpecs[1].codes()
+	stride, cols, rows := mcuX, mcuX, mcuY
+	if component == 0 {
+		// single component scan covers image only, not padding of MCUs
+		stride, cols, rows = mcuX*2, (width+7)/8, (height+7)/8
+	} else {
+		codes = huffmanSpecs[3].codes()
+	}
+	for by := 0; by < rows; by++ {
+		for bx := 0; bx < cols; bx++ {
+			b := blocks[component][by*stride+bx]
+			run := 0
+			for k := start; k <= end; k++ {
+				v := b[zigzag[k]]
+				if v == 0 {
+					run++
+					continue
+				}
+				for ; run > 15; run -= 16 {
+					e.writeCode(codes[0xF0])
+				}
+				e.writeValue(codes, run, v)
+				run = 0
+			}
+			if run > 0 {
+				// end of band in this block
+				e.writeCode(codes[0x00])
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// Writes Huffman code of zero run and size of v followed by bits of v.
+func (e *jpegEncoder) writeValue(codes [256]huffmanCode, run int, v int32) {
+	a, bits := v, v
+	if a < 0 {
+		a = -a
+		bits--
+	}
+	size := uint(0)
+	for ; a > 0; a >>= 1 {
+		size++
+	}
+	e.writeCode(codes[run<<4|int(size)])
+	if size > 0 {
+		e.writeBits(uint32(bits)&(1<<size-1), size)
+	}
+}
+
+func (e *jpegEncoder) writeCode(c huffmanCode) {
+	e.writeBits(c.code, c.length)
+}
+
+func (e *jpegEncoder) writeBits(bits uint32, n uint) {
+	e.bits = e.bits<<n | bits
+	e.nbits += n
+	for e.nbits >= 8 {
+		b := byte(e.bits >> (e.nbits - 8))
+		e.nbits -= 8
+		e.bits &= 1<<e.nbits - 1
+		e.write([]byte{b})
+		if b == 0xFF {
+			// stuffed zero byte, so data isn't taken for marker
+			e.write([]byte{0})
+		}
+	}
+}
+
+// Pads last byte of scan with 1 bits.
+func (e *jpegEncoder) flushBits() {
+	if e.nbits > 0 {
+		e.writeBits(1<<(8-e.nbits)-1, 8-e.nbits)
+	}
+}
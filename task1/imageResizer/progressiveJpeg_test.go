@@ -0,0 +1,241 @@
+package imageResizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+// Returns image of the size with gradients, sharp edges and noise,
+// so every band of DCT coefficients is used.
+func testPattern(width, height int) *image.RGBA {
+	rnd := rand.New(rand.NewSource(int64(width*1000 + height)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), uint8(rnd.Intn(256)), 255}
+			if (x/3+y/5)%2 == 0 {
+				c.R = 255 - c.R
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// Returns the image converted to given image type.
+func convertTo(img image.Image, kind string) image.Image {
+	b := img.Bounds()
+	ratios := map[string]image.YCbCrSubsampleRatio{
+		"444": image.YCbCrSubsampleRatio444,
+		"422": image.YCbCrSubsampleRatio422,
+		"420": image.YCbCrSubsampleRatio420,
+		"440": image.YCbCrSubsampleRatio440,
+		"411": image.YCbCrSubsampleRatio411,
+		"410": image.YCbCrSubsampleRatio410,
+	}
+	if ratio, ok := ratios[kind]; ok {
+		ycbcr := image.NewYCbCr(b, ratio)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := img.At(x, y).RGBA()
+				yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+				ycbcr.Y[ycbcr.YOffset(x, y)] = yy
+				ycbcr.Cb[ycbcr.COffset(x, y)] = cb
+				ycbcr.Cr[ycbcr.COffset(x, y)] = cr
+			}
+		}
+		return ycbcr
+	}
+
+	var dst interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	switch kind {
+	case "gray":
+		dst = image.NewGray(b)
+	case "nrgba":
+		dst = image.NewNRGBA(b)
+	case "cmyk":
+		dst = image.NewCMYK(b)
+	default:
+		return img
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x, y, img.At(x, y))
+		}
+	}
+	return dst
+}
+
+// Components and spectral selection of scans of progressive JPEG,
+// returns error if data isn't progressive JPEG with 2x2 subsampled chroma.
+func parseScans(data []byte) ([]jpegScan, error) {
+	var scans []jpegScan
+	sof := false
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, fmt.Errorf("no marker at %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xD9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		segment := data[i+4 : i+2+length]
+		switch marker {
+		case 0xC0, 0xC1:
+			return nil, fmt.Errorf("baseline SOF%d", marker-0xC0)
+		case 0xC2:
+			if !bytes.Equal(segment[5:], []byte{3, 1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1}) {
+				return nil, fmt.Errorf("components %v", segment[5:])
+			}
+			sof = true
+		case 0xDA:
+			n := int(segment[0])
+			scan := jpegScan{start: int(segment[1+2*n]), end: int(segment[2+2*n])}
+			for c := 0; c < n; c++ {
+				scan.components = append(scan.components, int(segment[1+2*c])-1)
+			}
+			scans = append(scans, scan)
+			// entropy coded data ends at the next marker, 0xFF is stuffed with 0
+			i += 2 + length
+			for i+1 < len(data) && (data[i] != 0xFF || data[i+1] == 0) {
+				i++
+			}
+			continue
+		}
+		i += 2 + length
+	}
+	if !sof {
+		return nil, fmt.Errorf("no SOF2")
+	}
+	return scans, nil
+}
+
+type jpegScan struct {
+	components []int
+	start, end int
+}
+
+func TestEncodeProgressiveJPEG_reference(t *testing.T) {
+	kinds := []string{"rgba", "nrgba", "gray", "cmyk", "444", "422", "420", "440", "411", "410"}
+	sizes := []image.Point{{1, 1}, {2, 3}, {7, 9}, {8, 8}, {15, 17}, {16, 16}, {17, 33}, {63, 1}, {1, 65}, {100, 75}}
+
+	for _, kind := range kinds {
+		for _, size := range sizes {
+			img := convertTo(testPattern(size.X, size.Y), kind)
+			for _, quality := range []int{1, 50, 90, 100} {
+				t.Run(fmt.Sprintf("%s/%dx%d/%d", kind, size.X, size.Y, quality), func(t *testing.T) {
+					var progressive, baseline bytes.Buffer
+					if err := encodeProgressiveJPEG(&progressive, img, quality); err != nil {
+						t.Fatal(err)
+					}
+					data := progressive.Bytes()
+
+					// every coefficient of every component is sent once,
+					// DC of all components comes first
+					scans, err := parseScans(data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var sent [3][64]int
+					for _, scan := range scans {
+						for _, c := range scan.components {
+							for k := scan.start; k <= scan.end; k++ {
+								sent[c][k]++
+							}
+						}
+					}
+					if len(scans) == 0 || len(scans[0].components) != 3 || scans[0].end != 0 {
+						t.Errorf("Got first scan %+v, but expected DC of all components", scans)
+					}
+					for c := range sent {
+						for k, n := range sent[c] {
+							if n != 1 {
+								t.Errorf("Got coefficient %d of component %d sent %v times, but expected once", k, c, n)
+							}
+						}
+					}
+
+					got, err := jpeg.Decode(bytes.NewReader(data))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got.Bounds().Size() != size {
+						t.Fatalf("Got %v image, but expected %v", got.Bounds().Size(), size)
+					}
+
+					// image/jpeg encodes the same image with the same tables,
+					// so both lose about the same
+					jpeg.Encode(&baseline, img, &jpeg.Options{Quality: quality})
+					want, _ := jpeg.Decode(&baseline)
+					if got, want := meanError(got, img), meanError(want, img); got > want*1.1+1 {
+						t.Errorf("Got mean error %.2f, but expected at most %.2f", got, want)
+					}
+					// coefficients are hardly rounded at quality 100, so any
+					// wrongly coded band shows up in some pixel
+					if d := maxError(got, want); quality == 100 && d > 8 {
+						t.Errorf("Got pixel differing by %v from image/jpeg, but expected at most %v", d, 8)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEncodeProgressiveJPEG_subImage(t *testing.T) {
+	// image which doesn't start at 0,0
+	img := testPattern(40, 40).SubImage(image.Rect(13, 5, 32, 30))
+	var buf bytes.Buffer
+	if err := encodeProgressiveJPEG(&buf, img, 90); err != nil {
+		t.Fatal(err)
+	}
+	got, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var baseline bytes.Buffer
+	jpeg.Encode(&baseline, img, &jpeg.Options{Quality: 90})
+	want, _ := jpeg.Decode(&baseline)
+	if got, want := meanError(got, img), meanError(want, img); got > want*1.1+1 {
+		t.Errorf("Got mean error %.2f, but expected at most %.2f", got, want)
+	}
+}
+
+func TestEncodeProgressiveJPEG_size(t *testing.T) {
+	for _, size := range []image.Point{{0, 10}, {10, 0}, {0x10000, 1}} {
+		img := image.NewGray(image.Rect(0, 0, size.X, size.Y))
+		if err := encodeProgressiveJPEG(&bytes.Buffer{}, img, 90); err == nil {
+			t.Errorf("%v: got no error, but expected error", size)
+		}
+	}
+}
+
+// Returns the largest difference of channels of the images of the same size.
+func maxError(got, want image.Image) int {
+	gb, wb := got.Bounds(), want.Bounds()
+	max := 0
+	for y := 0; y < gb.Dy(); y++ {
+		for x := 0; x < gb.Dx(); x++ {
+			r1, g1, b1, _ := got.At(gb.Min.X+x, gb.Min.Y+y).RGBA()
+			r2, g2, b2, _ := want.At(wb.Min.X+x, wb.Min.Y+y).RGBA()
+			for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				if d < 0 {
+					d = -d
+				}
+				if d > max {
+					max = d
+				}
+			}
+		}
+	}
+	return max
+}
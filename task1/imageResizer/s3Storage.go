@@ -0,0 +1,170 @@
+package imageResizer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps images in a bucket of S3 compatible object storage
+// (AWS S3, MinIO, Ceph etc.). Objects are addressed path-style:
+// endpoint/bucket/name.
+type S3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// Returns S3Storage which puts images into bucket available at endpoint,
+// e.g. "https://s3.eu-central-1.amazonaws.com" or "http://localhost:9000".
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string) *S3Storage {
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Storage{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		region:    region,
+		bucket:    bucket,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *S3Storage) Save(name string, data io.Reader) (string, error) {
+	body, err := ioutil.ReadAll(data)
+	if err != nil {
+		return "", err
+	}
+
+	objectURL := s.objectURL(name)
+	req, err := http.NewRequest(http.MethodPut, objectURL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("s3: put %s: %s %s", name, resp.Status, msg)
+	}
+
+	return objectURL, nil
+}
+
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	case resp.StatusCode/100 != 2:
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3: get %s: %s %s", name, resp.Status, msg)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// S3 answers 204 for missing objects too, 404 is for other stores
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3: delete %s: %s %s", name, resp.Status, msg)
+	}
+	return nil
+}
+
+func (s *S3Storage) objectURL(name string) string {
+	return s.endpoint + "/" + s.bucket + "/" + strings.TrimPrefix(name, "/")
+}
+
+// Signs request with AWS Signature Version 4.
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
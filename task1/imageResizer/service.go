@@ -0,0 +1,208 @@
+package imageResizer
+
+import (
+	"context"
+	"fmt"
+	"github.com/dairovolzhas/dar-internship/task1/imageURL"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Service processes, stores and serves images. It keeps settings, storage
+// and index shared by all images, HTTP handlers are its methods.
+// Settings are changed by Set* methods only before service is used.
+type Service struct {
+	// storage where images are saved
+	storage Storage
+	// index of saved images
+	index Index
+	// presets rendered by SaveImages
+	presets []Preset
+	// Formats images are saved in by format of uploaded image.
+	// Format of uploaded image is kept if it's missing here.
+	outputFormats map[string]string
+	// Maximum image size in megabytes
+	maxImageSize int64
+	// Maximum number of pixels of decoded image, protects from
+	// small files which declare huge dimensions
+	maxImagePixels int64
+	// Memory which decoded images may take at once,
+	// requests wait for their turn when it's used up
+	decodeBudget *memorySemaphore
+	// locks of images whose metadata is read, changed and put back
+	imageLocks keyedMutex
+	// Number of variants of one image rendered at once
+	renderWorkers int
+	// Number of images of batch upload processed at once
+	batchWorkers int
+	// Maximum size of batch request in megabytes
+	maxBatchSize int64
+	// Maximum number of images in batch, including files in zip archives
+	maxBatchFiles int
+	// Strip Exif metadata (GPS position, camera, etc.) from saved original image
+	stripMetadata bool
+	// watermark stamped on images, nil if there is none
+	watermark *Watermark
+	// queue of asynchronous processing, nil if it's disabled
+	jobs *JobQueue
+	// Signer of URLs returned to clients, nil if URLs aren't signed
+	urlSigner *imageURL.Signer
+	// How long signed URLs are valid
+	urlTTL time.Duration
+	// Directory where images rendered on the fly are cached
+	cacheDir string
+	// Maximum size of cached images in bytes, least recently used
+	// images are removed when it's exceeded, no limit if zero
+	maxCacheSize int64
+	// Maximum width and height of image rendered on the fly
+	maxDynamicSize uint
+	// Allowed widths and heights of image rendered on the fly,
+	// any size up to maxDynamicSize is allowed if empty
+	allowedDynamicSizes []uint
+}
+
+// service used by NewImageResizer, it has default settings
+var defaultService = newService()
+
+// Returns service with default settings, images are saved
+// to local "resizedImages" directory and index isn't persisted.
+func newService() *Service {
+	return &Service{
+		storage:        NewLocalStorage("resizedImages", ""),
+		index:          &FileIndex{entries: map[string]*Metadata{}},
+		presets:        []Preset{NormalPreset, ThumbnailPreset},
+		outputFormats:  map[string]string{WEBP: PNG}, // there is no WebP encoder
+		maxImageSize:   5,
+		maxImagePixels: 50 * 1000 * 1000,
+		decodeBudget:   newMemorySemaphore(512 * 1024 * 1024),
+		renderWorkers:  runtime.NumCPU(),
+		batchWorkers:   runtime.NumCPU(),
+		maxBatchSize:   100,
+		maxBatchFiles:  50,
+		stripMetadata:  true,
+		urlTTL:         24 * time.Hour,
+		cacheDir:       "cache",
+		maxCacheSize:   512 * 1024 * 1024,
+		maxDynamicSize: 2000,
+	}
+}
+
+// Returns service configured by c. Job queue is started if c.JobsDir
+// is set, it has to be stopped by Shutdown.
+func NewService(c Config) (*Service, error) {
+	s := newService()
+
+	switch c.Storage.Type {
+	case "", "local":
+		// images are served by this service at /files/ unless they are
+		// served from somewhere else
+		baseURL := c.Storage.BaseURL
+		if baseURL == "" {
+			baseURL = "http://localhost:" + c.Port + "/files"
+		}
+		s.SetStorage(NewLocalStorage(c.Storage.Root, baseURL))
+	case "s3":
+		s.SetStorage(NewS3Storage(c.Storage.S3Endpoint, c.Storage.S3Region,
+			c.Storage.S3Bucket, c.Storage.S3AccessKey, c.Storage.S3SecretKey))
+	default:
+		return nil, fmt.Errorf("Unknown storage %q!!!", c.Storage.Type)
+	}
+
+	if c.IndexFile != "" {
+		index, err := NewFileIndex(c.IndexFile)
+		if err != nil {
+			return nil, fmt.Errorf("indexFile: %v", err)
+		}
+		s.SetIndex(index)
+	}
+
+	presets := c.Presets
+	if len(presets) == 0 && c.PresetsFile != "" {
+		var err error
+		presets, err = LoadPresets(c.PresetsFile)
+		if os.IsNotExist(err) && c.PresetsFile == defaultPresetsFile {
+			// server is started outside of directory of the file
+			log.Printf("Presets file %s is not found, built-in presets are used", c.PresetsFile)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if presets != nil {
+		if err := s.SetPresets(presets); err != nil {
+			return nil, err
+		}
+	}
+	if c.OutputFormats != nil {
+		if err := s.SetOutputFormats(c.OutputFormats); err != nil {
+			return nil, fmt.Errorf("outputFormats: %v", err)
+		}
+	}
+
+	s.SetLimits(c.MaxImageSize, c.MaxImagePixels)
+	s.SetMemoryBudget(c.MemoryBudget)
+	s.SetBatchLimits(c.MaxBatchSize, c.MaxBatchFiles)
+	s.SetStripMetadata(c.StripMetadata)
+	if err := s.SetWatermark(c.Watermark); err != nil {
+		return nil, fmt.Errorf("watermark: %v", err)
+	}
+
+	// URLs are signed only if key is set, the same key is given
+	// to services which make links to images
+	if c.SigningKey != "" {
+		publicURL := c.PublicURL
+		if publicURL == "" {
+			publicURL = "http://localhost:" + c.Port
+		}
+		s.SetURLSigner(imageURL.NewSigner(publicURL, []byte(c.SigningKey)), time.Duration(c.URLTTL))
+	}
+
+	s.SetCacheDir(c.CacheDir)
+	s.SetCacheSize(c.CacheSize)
+	s.SetDynamicSizeLimits(c.DynamicMaxSize, c.DynamicSizes)
+
+	// workers start at once, so queue is created when everything is set
+	if c.JobsDir != "" {
+		jobs, err := NewJobQueue(s, c.JobsDir, c.JobWorkers, time.Duration(c.JobRetention))
+		if err != nil {
+			return nil, fmt.Errorf("jobsDir: %v", err)
+		}
+		s.SetJobQueue(jobs)
+	}
+	return s, nil
+}
+
+// Returns handler of HTTP API of the service. Requests are traced and
+// Prometheus metrics of the process are served at /metrics.
+func (s *Service) Handler() http.Handler {
+	r := mux.NewRouter()
+
+	r.Methods("POST").Path("/image").HandlerFunc(s.ImageProcessingHandler)
+	r.Methods("POST").Path("/images").HandlerFunc(s.BatchProcessingHandler)
+	r.Methods("GET").Path("/images").HandlerFunc(s.ListImagesHandler)
+	r.Methods("GET").Path("/images/{id}").HandlerFunc(s.ImageMetadataHandler)
+	r.Methods("GET").Path("/images/{id}/similar").HandlerFunc(s.SimilarImagesHandler)
+	r.Methods("GET").Path("/images/{id}/{preset}").HandlerFunc(s.VariantImageHandler)
+	r.Methods("DELETE").Path("/images/{id}").HandlerFunc(s.DeleteImageHandler)
+	r.Methods("GET").Path("/image/jobs/{id}").HandlerFunc(s.JobStatusHandler)
+	r.Methods("GET").Path("/image/{id}").HandlerFunc(s.DynamicImageHandler)
+	r.Methods("GET").Path("/files/{name}").HandlerFunc(s.ServeImageHandler)
+	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
+
+	// middlewares run for matched routes only, so span is named by route
+	r.Use(traceRequests, countInFlight)
+	return r
+}
+
+// Stops job queue after jobs being processed are finished, queued jobs
+// are processed after restart. Returns error of ctx if it's done first.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.jobs == nil {
+		return nil
+	}
+	return s.jobs.Shutdown(ctx)
+}
@@ -0,0 +1,109 @@
+package imageResizer
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns service with default settings which keeps images in temporary
+// directories. It's used by NewImageResizer until the test ends.
+func useTestService(t testing.TB) *Service {
+	s := newService()
+	s.SetStorage(NewLocalStorage(t.TempDir(), ""))
+	s.SetCacheDir(t.TempDir())
+
+	previous := defaultService
+	defaultService = s
+	t.Cleanup(func() { defaultService = previous })
+	return s
+}
+
+func TestNewService(t *testing.T) {
+	dir := t.TempDir()
+	c := DefaultConfig()
+	c.Storage.Root = filepath.Join(dir, "images")
+	c.IndexFile = filepath.Join(dir, "index.json")
+	c.PresetsFile = "testdata/presets.json"
+	c.JobsDir = filepath.Join(dir, "jobs")
+	c.CacheDir = filepath.Join(dir, "cache")
+	c.MaxImageSize = 1
+
+	s, err := NewService(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	if len(s.Presets()) != 4 {
+		t.Errorf("Got %v presets, but expected %v", len(s.Presets()), 4)
+	}
+	if s.maxImageSize != 1 {
+		t.Errorf("Got %v, but expected %v", s.maxImageSize, 1)
+	}
+	if s.jobs == nil {
+		t.Error("Got no job queue, but expected one")
+	}
+
+	// image is saved to configured storage and served by handler of the service
+	ir, err := s.NewImageResizer(bytes.NewReader(readTestFile(t, "testdata/JPEGImage.jpeg")), "JPEGImage.jpeg", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if _, err := ir.SaveImages(); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/images/"+ir.ID(), nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	c.Storage.Type = "ftp"
+	if _, err := NewService(c); err == nil {
+		t.Error("Got no error for unknown storage, but expected one")
+	}
+}
+
+func TestNewService_presetsFile(t *testing.T) {
+	dir := t.TempDir()
+	c := DefaultConfig()
+	c.Storage.Root = filepath.Join(dir, "images")
+	c.IndexFile = ""
+	c.JobsDir = ""
+	c.CacheDir = filepath.Join(dir, "cache")
+
+	// default file is missing in working directory of the test
+	s, err := NewService(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := newService().Presets(); len(s.Presets()) != len(want) {
+		t.Errorf("Got %v presets, but expected %v built-in", len(s.Presets()), len(want))
+	}
+
+	c.PresetsFile = filepath.Join(dir, "presets.json")
+	if _, err := NewService(c); err == nil {
+		t.Error("Got no error for missing presets file, but expected one")
+	}
+}
+
+func TestService_Shutdown(t *testing.T) {
+	s := useTestService(t)
+	q, err := NewJobQueue(s, t.TempDir(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetJobQueue(q)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Got %v, but expected workers to stop", err)
+	}
+}
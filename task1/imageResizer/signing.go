@@ -0,0 +1,66 @@
+package imageResizer
+
+import (
+	"github.com/dairovolzhas/dar-internship/task1/imageURL"
+	"net/http"
+	"time"
+)
+
+// Query parameter "delete=true" is signed into URLs which allow to delete
+// the image, so signed URL of its metadata can't be used to delete it.
+const deleteParam = "delete"
+
+// Enables signed URLs: URLs of saved images in responses are signed by s
+// and valid for ttl, images are served only by signed URLs.
+func (s *Service) SetURLSigner(signer *imageURL.Signer, ttl time.Duration) {
+	s.urlSigner = signer
+	s.urlTTL = ttl
+}
+
+// Returns variants with URLs signed for clients, variants are returned
+// as they are if signing is disabled.
+func (s *Service) signVariants(id string, variants []Variant) []Variant {
+	if s.urlSigner == nil {
+		return variants
+	}
+	signed := make([]Variant, len(variants))
+	for i, v := range variants {
+		v.URL = s.urlSigner.URL(id, v.Preset, s.urlTTL)
+		signed[i] = v
+	}
+	return signed
+}
+
+// Returns copy of result with signed URLs.
+func (s *Service) signResult(r *Result) *Result {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Variants = s.signVariants(r.ID, r.Variants)
+	return &c
+}
+
+// Returns copy of metadata with signed URLs.
+func (s *Service) signMetadata(m *Metadata) *Metadata {
+	c := *m
+	c.Variants = s.signVariants(m.ID, m.Variants)
+	return &c
+}
+
+// Checks signature of requested URL if signing is enabled,
+// otherwise writes error response and returns false.
+func (s *Service) verifySignature(w http.ResponseWriter, r *http.Request) bool {
+	if s.urlSigner == nil {
+		return true
+	}
+	switch err := s.urlSigner.Verify(r.URL, time.Now()); err {
+	case nil:
+		return true
+	case imageURL.ErrExpired:
+		writeErrorResponse(w, http.StatusForbidden, CodeExpiredURL, err.Error())
+	default:
+		writeErrorResponse(w, http.StatusForbidden, CodeInvalidSignature, err.Error())
+	}
+	return false
+}
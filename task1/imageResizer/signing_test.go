@@ -0,0 +1,127 @@
+package imageResizer
+
+import (
+	"encoding/json"
+	"github.com/dairovolzhas/dar-internship/task1/imageURL"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLs(t *testing.T) {
+	s := useTestService(t)
+	signer := imageURL.NewSigner("http://localhost:8080", []byte("secret"))
+	s.SetURLSigner(signer, time.Hour)
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/images").HandlerFunc(s.ListImagesHandler)
+	r.Methods("GET").Path("/images/{id}").HandlerFunc(s.ImageMetadataHandler)
+	r.Methods("DELETE").Path("/images/{id}").HandlerFunc(s.DeleteImageHandler)
+	r.Methods("GET").Path("/images/{id}/similar").HandlerFunc(s.SimilarImagesHandler)
+	r.Methods("GET").Path("/images/{id}/{preset}").HandlerFunc(s.VariantImageHandler)
+	r.Methods("GET").Path("/image/{id}").HandlerFunc(s.DynamicImageHandler)
+	r.Methods("GET").Path("/files/{name}").HandlerFunc(s.ServeImageHandler)
+	get := func(rawURL string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", rawURL, nil))
+		return rec
+	}
+
+	ir, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if _, err := ir.SaveImages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// metadata returns signed URLs pointing to the service,
+	// so it's requested by signed URL too
+	signed, _ := signer.Sign("http://localhost:8080/images/"+ir.ID(), time.Now().Add(time.Minute))
+	u, _ := url.Parse(signed)
+	rec := get(u.RequestURI())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	meta := Metadata{}
+	if err := json.NewDecoder(rec.Body).Decode(&meta); err != nil {
+		t.Fatal(err)
+	}
+	thumbnail := meta.variant(ThumbnailPreset.Name).URL
+	if !strings.HasPrefix(thumbnail, "http://localhost:8080/images/"+ir.ID()+"/"+ThumbnailPreset.Name+"?") {
+		t.Fatalf("Got %v, but expected signed URL of thumbnail", thumbnail)
+	}
+
+	dynamic, _ := signer.Sign("http://localhost:8080/image/"+ir.ID()+"?w=10&h=10", time.Now().Add(time.Minute))
+	expired, _ := signer.Sign("http://localhost:8080/images/"+ir.ID()+"/original", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantCode   string
+	}{
+		{"signed variant", thumbnail, http.StatusOK, ""},
+		{"signed dynamic", dynamic, http.StatusOK, ""},
+		{"other preset", strings.Replace(thumbnail, ThumbnailPreset.Name, NormalPreset.Name, 1), http.StatusForbidden, CodeInvalidSignature},
+		{"other size", strings.Replace(dynamic, "w=10", "w=20", 1), http.StatusForbidden, CodeInvalidSignature},
+		{"expired", expired, http.StatusForbidden, CodeExpiredURL},
+		{"not signed variant", "/images/" + ir.ID() + "/original", http.StatusForbidden, CodeInvalidSignature},
+		{"not signed file", "/files/" + imageName(ir.ID(), OriginalVariant, PNG), http.StatusForbidden, CodeInvalidSignature},
+		{"not signed dynamic", "/image/" + ir.ID() + "?w=10&h=10", http.StatusForbidden, CodeInvalidSignature},
+		{"not signed metadata", "/images/" + ir.ID(), http.StatusForbidden, CodeInvalidSignature},
+		{"not signed list", "/images", http.StatusForbidden, CodeInvalidSignature},
+		{"not signed similar", "/images/" + ir.ID() + "/similar", http.StatusForbidden, CodeInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := get(u.RequestURI())
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Got %v, but expected %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			resp := ErrorResponse{}
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("Got %v, but expected %v", resp.Code, tt.wantCode)
+			}
+		})
+	}
+
+	t.Run("delete", func(t *testing.T) {
+		remove := func(rawURL string) int {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest("DELETE", u.RequestURI(), nil))
+			return rec.Code
+		}
+		deleting, _ := signer.Sign("http://localhost:8080/images/"+ir.ID()+"?delete=true", time.Now().Add(time.Minute))
+
+		if code := remove("/images/" + ir.ID()); code != http.StatusForbidden {
+			t.Errorf("not signed: got %v, but expected %v", code, http.StatusForbidden)
+		}
+		// URL of metadata is signed without delete=true
+		if code := remove(signed); code != http.StatusForbidden {
+			t.Errorf("signed metadata: got %v, but expected %v", code, http.StatusForbidden)
+		}
+		if code := remove(deleting); code != http.StatusNoContent {
+			t.Errorf("signed for deleting: got %v, but expected %v", code, http.StatusNoContent)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package imageResizer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage is a place where processed images are kept.
+type Storage interface {
+	// Save writes data under the given name and returns URL
+	// where saved object can be reached.
+	Save(name string, data io.Reader) (string, error)
+	// Open returns content of the named object.
+	// Error satisfies os.IsNotExist if there is no such object.
+	Open(name string) (io.ReadCloser, error)
+	// Delete removes the named object, it's not an error
+	// if there is no such object.
+	Delete(name string) error
+}
+
+// Sets storage where SaveImages will put images.
+func (s *Service) SetStorage(storage Storage) {
+	s.storage = storage
+}
+
+// Returns name of saved image: ID of the image, preset and format.
+func imageName(id, preset, format string) string {
+	return id + "_" + preset + "." + format
+}
+
+// Splits name of saved image to its parts, ok is false if name
+// isn't made by imageName.
+func parseImageName(name string) (id, preset, format string, ok bool) {
+	i := strings.Index(name, "_")
+	j := strings.LastIndex(name, ".")
+	if i <= 0 || j <= i+1 || j == len(name)-1 {
+		return "", "", "", false
+	}
+	return name[:i], name[i+1 : j], name[j+1:], true
+}
+
+// LocalStorage keeps images in directory of local filesystem.
+type LocalStorage struct {
+	root    string
+	baseURL string
+}
+
+// Returns LocalStorage which saves images into root directory.
+// If baseURL is empty, resulting URLs are file:// URLs to saved images,
+// otherwise saved image name is appended to the baseURL.
+func NewLocalStorage(root, baseURL string) *LocalStorage {
+	return &LocalStorage{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (s *LocalStorage) Save(name string, data io.Reader) (string, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	// image is written to temporary file and renamed, so partially
+	// written image is never served
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(path, content); err != nil {
+		return "", err
+	}
+	// temporary files are readable only by owner
+	if err := os.Chmod(path, 0644); err != nil {
+		return "", err
+	}
+
+	if s.baseURL != "" {
+		return s.baseURL + "/" + name, nil
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String(), nil
+}
+
+func (s *LocalStorage) Open(name string) (io.ReadCloser, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *LocalStorage) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Returns absolute path of the named image, name must stay inside of root.
+func (s *LocalStorage) path(name string) (string, error) {
+	root, err := filepath.Abs(s.root)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, root+string(filepath.Separator)) {
+		return "", errors.New("Invalid image name!!!")
+	}
+	return path, nil
+}
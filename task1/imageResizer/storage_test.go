@@ -0,0 +1,325 @@
+package imageResizer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestLocalStorage_Save(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		name    string
+		baseURL string
+		wantURL string
+	}{
+		{"a.png", "", "file://" + filepath.ToSlash(filepath.Join(root, "a.png"))},
+		{"b/c.jpeg", "http://localhost:8080/images/", "http://localhost:8080/images/b/c.jpeg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewLocalStorage(root, tt.baseURL)
+			got, err := s.Save(tt.name, strings.NewReader("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.wantURL {
+				t.Errorf("Got %v, but expected %v", got, tt.wantURL)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(tt.name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "data" {
+				t.Errorf("Got %q, but expected %q", data, "data")
+			}
+
+			rc, err := s.Open(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ = ioutil.ReadAll(rc)
+			rc.Close()
+			if string(data) != "data" {
+				t.Errorf("Got %q, but expected %q", data, "data")
+			}
+		})
+	}
+
+	t.Run("outside of root", func(t *testing.T) {
+		s := NewLocalStorage(root, "")
+		if _, err := s.Save("../escaped.png", strings.NewReader("data")); err == nil {
+			t.Error("Expected error for name outside of root")
+		}
+		if _, err := os.Stat(filepath.Join(root, "..", "escaped.png")); !os.IsNotExist(err) {
+			t.Error("Image saved outside of root")
+		}
+		if err := s.Delete("../escaped.png"); err == nil {
+			t.Error("Expected error for name outside of root")
+		}
+	})
+
+	t.Run("failed write", func(t *testing.T) {
+		s := NewLocalStorage(root, "")
+		broken := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+		if _, err := s.Save("a.png", broken); err == nil {
+			t.Error("Expected error of the reader")
+		}
+		// previous image is kept as it was
+		data, _ := ioutil.ReadFile(filepath.Join(root, "a.png"))
+		if string(data) != "data" {
+			t.Errorf("Got %q, but expected %q", data, "data")
+		}
+		if files, _ := filepath.Glob(filepath.Join(root, ".tmp-*")); len(files) != 0 {
+			t.Errorf("Got temporary files %v", files)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := NewLocalStorage(root, "")
+		if err := s.Delete("a.png"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Open("a.png"); !os.IsNotExist(err) {
+			t.Errorf("Got %v, but expected not exist error", err)
+		}
+		// deleting missing image is fine
+		if err := s.Delete("a.png"); err != nil {
+			t.Errorf("Got %v, but expected no error", err)
+		}
+	})
+}
+
+// fakeS3 is a minimal stand-in for S3 compatible storage.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if r.Method == http.MethodGet {
+		f.mu.Lock()
+		data, ok := f.objects[r.URL.Path]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		f.mu.Lock()
+		delete(f.objects, r.URL.Path)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.objects[r.URL.Path] = body
+	f.mu.Unlock()
+}
+
+func TestS3Storage_Save(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	s := NewS3Storage(ts.URL, "", "images", "key", "secret")
+	got, err := s.Save("photo_normal.jpeg", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ts.URL + "/images/photo_normal.jpeg"; got != want {
+		t.Errorf("Got %v, but expected %v", got, want)
+	}
+	if data := fake.objects["/images/photo_normal.jpeg"]; string(data) != "data" {
+		t.Errorf("Got %q, but expected %q", data, "data")
+	}
+
+	rc, err := s.Open("photo_normal.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(data) != "data" {
+		t.Errorf("Got %q, but expected %q", data, "data")
+	}
+	if _, err := s.Open("missing.jpeg"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+
+	if err := s.Delete("photo_normal.jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Open("photo_normal.jpeg"); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected not exist error", err)
+	}
+
+	s = NewS3Storage(ts.URL, "", "images", "wrong", "secret")
+	if _, err := s.Save("photo_normal.jpeg", strings.NewReader("data")); err == nil {
+		t.Error("Expected error for rejected request")
+	}
+	if err := s.Delete("photo_normal.jpeg"); err == nil {
+		t.Error("Expected error for rejected request")
+	}
+}
+
+func TestParseImageName(t *testing.T) {
+	tests := []struct {
+		name               string
+		id, preset, format string
+		ok                 bool
+	}{
+		{"abc_original.png", "abc", "original", "png", true},
+		{"abc_my_preset.jpeg", "abc", "my_preset", "jpeg", true},
+		{"abc.png", "", "", "", false},
+		{"abc_.png", "", "", "", false},
+		{"_original.png", "", "", "", false},
+		{"abc_original.", "", "", "", false},
+	}
+	for _, tt := range tests {
+		id, preset, format, ok := parseImageName(tt.name)
+		if id != tt.id || preset != tt.preset || format != tt.format || ok != tt.ok {
+			t.Errorf("%s: got %q %q %q %v, but expected %q %q %q %v", tt.name,
+				id, preset, format, ok, tt.id, tt.preset, tt.format, tt.ok)
+		}
+	}
+}
+
+// Storage which returns files that can't seek, like S3Storage.
+type streamingStorage struct {
+	Storage
+}
+
+func (s streamingStorage) Open(name string) (io.ReadCloser, error) {
+	rc, err := s.Storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{rc}, nil
+}
+
+func TestServeImageHandler(t *testing.T) {
+	s := useTestService(t)
+	local := NewLocalStorage(t.TempDir(), "")
+	s.SetStorage(local)
+
+	ir, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	result, err := ir.SaveImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := result.Variants[0]
+	data, err := ioutil.ReadFile(localPath(original.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	originalName := imageName(ir.ID(), OriginalVariant, PNG)
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/files/{name}").HandlerFunc(s.ServeImageHandler)
+
+	tests := []struct {
+		name         string
+		storage      Storage
+		file         string
+		header       map[string]string
+		wantStatus   int
+		wantLength   int
+		cacheControl string
+	}{
+		{"original", local, originalName, nil, http.StatusOK, len(data), immutableCacheControl},
+		{"variant", local, imageName(ir.ID(), ThumbnailPreset.Name, PNG), nil, http.StatusOK, -1, variantCacheControl},
+		{"if none match", local, originalName, map[string]string{"If-None-Match": etag}, http.StatusNotModified, 0, immutableCacheControl},
+		{"other etag", local, originalName, map[string]string{"If-None-Match": `"other"`}, http.StatusOK, len(data), immutableCacheControl},
+		{"if modified since", local, originalName,
+			map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			http.StatusNotModified, 0, immutableCacheControl},
+		{"range", local, originalName, map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, 10, immutableCacheControl},
+		{"range of stream", streamingStorage{local}, originalName, map[string]string{"Range": "bytes=10-19"}, http.StatusPartialContent, 10, immutableCacheControl},
+		{"unknown preset", local, imageName(ir.ID(), "unknown", PNG), nil, http.StatusNotFound, -1, ""},
+		{"other format", local, imageName(ir.ID(), OriginalVariant, JPEG), nil, http.StatusNotFound, -1, ""},
+		{"unknown image", local, imageName("unknown", OriginalVariant, PNG), nil, http.StatusNotFound, -1, ""},
+		{"invalid name", local, "image.png", nil, http.StatusNotFound, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetStorage(tt.storage)
+			req := httptest.NewRequest("GET", "/files/"+tt.file, nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Got %v, but expected %v: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantLength >= 0 && rec.Body.Len() != tt.wantLength {
+				t.Errorf("Got %v bytes, but expected %v", rec.Body.Len(), tt.wantLength)
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Got %v, but expected %v", got, tt.cacheControl)
+			}
+			if tt.file == originalName {
+				if got := rec.Header().Get("ETag"); got != etag {
+					t.Errorf("Got %v, but expected %v", got, etag)
+				}
+			}
+			if tt.wantStatus == http.StatusPartialContent && !bytes.Equal(rec.Body.Bytes(), data[10:20]) {
+				t.Error("Got other bytes of the range")
+			}
+		})
+	}
+
+	t.Run("no hash in metadata", func(t *testing.T) {
+		s.SetStorage(local)
+		meta, _ := s.index.Get(ir.ID())
+		meta.Variants[0].Hash = ""
+		s.index.Put(meta)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/files/"+originalName, nil))
+		if got := rec.Header().Get("ETag"); got != etag {
+			t.Errorf("Got %v, but expected %v", got, etag)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), data) {
+			t.Error("Got other content")
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package imageResizer
+
+import (
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"net/http"
+)
+
+// Spans are created by tracer provider set by otel.SetTracerProvider,
+// they are dropped until it's set.
+var tracer = otel.Tracer("github.com/dairovolzhas/dar-internship/task1/imageResizer")
+
+// Trace context is read from W3C "traceparent" and "tracestate" headers
+// and "baggage" header of incoming requests, so spans of the service
+// continue trace of the client.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Middleware which starts server span of every request, its context is
+// context of the request. Span is named by method and route, e.g.
+// "GET /images/{id}", so all requests of handler have the same name.
+var traceRequests mux.MiddlewareFunc = otelhttp.NewMiddleware("imageResizer",
+	otelhttp.WithPropagators(propagator),
+	otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				return r.Method + " " + path
+			}
+		}
+		return r.Method + " " + operation
+	}),
+)
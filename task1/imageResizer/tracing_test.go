@@ -0,0 +1,60 @@
+package imageResizer
+
+import (
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"testing"
+)
+
+func TestTraceRequests(t *testing.T) {
+	// tracer of the package delegates to the first provider set globally,
+	// so it's set once and isn't restored
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	s := useTestService(t)
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if rec := postImage(t, s.Handler(), "image.jpeg", readTestFile(t, "testdata/JPEGImage.jpeg"), header); rec.Code != http.StatusCreated {
+		t.Fatalf("Got %v, but expected %v: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+
+	spans := recorder.Ended()
+	var server sdktrace.ReadOnlySpan
+	stages := map[string]int{}
+	for _, span := range spans {
+		if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("%s: got trace %v, but expected trace of the request", span.Name(), got)
+		}
+		if span.Name() == "POST /image" {
+			server = span
+		} else {
+			stages[span.Name()]++
+		}
+	}
+	if server == nil {
+		t.Fatalf("Got no span of the request among %v spans", len(spans))
+	}
+	if got := server.Parent().SpanID().String(); got != "00f067aa0ba902b7" || !server.Parent().IsRemote() {
+		t.Errorf("Got parent %v, but expected span of the client", got)
+	}
+
+	for _, span := range spans {
+		if span != server && span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s: got parent %v, but expected span of the request", span.Name(), span.Parent().SpanID())
+		}
+	}
+	for stage, want := range map[string]int{
+		stageDecode: 1,
+		stageCrop:   2,
+		stageResize: 2,
+		stageEncode: 3,
+		stageSave:   3,
+	} {
+		if stages[stage] != want {
+			t.Errorf("%s: got %v spans, but expected %v", stage, stages[stage], want)
+		}
+	}
+}
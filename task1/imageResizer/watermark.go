@@ -0,0 +1,169 @@
+package imageResizer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+)
+
+// watermark positions
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+// Watermark is logo or text stamped on variants of presets with Watermark set.
+type Watermark struct {
+	// Path to image file of the logo, PNG with transparency is the best
+	Image string `json:"image,omitempty"`
+	// Text stamped if there is no image
+	Text string `json:"text,omitempty"`
+	// One of Position* constants, PositionBottomRight if empty.
+	Position string `json:"position,omitempty"`
+	// Opacity from 0 to 1, 0.5 if zero.
+	Opacity float64 `json:"opacity,omitempty"`
+	// Width of watermark relative to width of the image, 0.2 if zero.
+	Scale float64 `json:"scale,omitempty"`
+	// Stamp saved original image too. Original without watermark is kept
+	// privately, so other variants are rendered from it.
+	Original bool `json:"original,omitempty"`
+
+	// loaded image or rendered text
+	mark image.Image
+}
+
+var positions = map[string]bool{
+	PositionTopLeft:     true,
+	PositionTopRight:    true,
+	PositionBottomLeft:  true,
+	PositionBottomRight: true,
+	PositionCenter:      true,
+}
+
+// Sets watermark stamped on variants of presets with Watermark set,
+// nil disables watermarks.
+func (s *Service) SetWatermark(w *Watermark) error {
+	if w == nil {
+		s.watermark = nil
+		return nil
+	}
+
+	c := *w
+	if c.Position == "" {
+		c.Position = PositionBottomRight
+	}
+	if !positions[c.Position] {
+		return fmt.Errorf("Unknown watermark position %q!!!", c.Position)
+	}
+	if c.Opacity == 0 {
+		c.Opacity = 0.5
+	}
+	if c.Opacity < 0 || c.Opacity > 1 {
+		return errors.New("Watermark opacity must be between 0 and 1!!!")
+	}
+	if c.Scale == 0 {
+		c.Scale = 0.2
+	}
+	if c.Scale < 0 || c.Scale > 1 {
+		return errors.New("Watermark scale must be between 0 and 1!!!")
+	}
+
+	switch {
+	case c.Image != "":
+		f, err := os.Open(c.Image)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		mark, _, err := image.Decode(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", c.Image, err)
+		}
+		c.mark = mark
+	case c.Text != "":
+		c.mark = textMark(c.Text)
+	default:
+		return errors.New("Watermark image or text required!!!")
+	}
+
+	s.watermark = &c
+	return nil
+}
+
+// Returns image of white text with dark outline, so it's seen
+// on both light and dark images.
+func textMark(text string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil() + 2
+	height := face.Metrics().Height.Ceil() + 2
+	mark := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	d := &font.Drawer{Dst: mark, Face: face}
+	d.Src = image.NewUniform(color.NRGBA{0, 0, 0, 160})
+	for _, offset := range []image.Point{{0, 1}, {2, 1}, {1, 0}, {1, 2}} {
+		d.Dot = fixed.P(offset.X, face.Metrics().Ascent.Ceil()+offset.Y)
+		d.DrawString(text)
+	}
+	d.Src = image.White
+	d.Dot = fixed.P(1, face.Metrics().Ascent.Ceil()+1)
+	d.DrawString(text)
+	return mark
+}
+
+// Returns image with the watermark, every frame of animation is stamped.
+func (w *Watermark) apply(img image.Image) image.Image {
+	if a, ok := img.(*animation); ok {
+		stamped := *a
+		stamped.frames = make([]image.Image, len(a.frames))
+		for i, frame := range a.frames {
+			stamped.frames[i] = w.stamp(frame)
+		}
+		stamped.Image = stamped.frames[0]
+		return &stamped
+	}
+	return w.stamp(img)
+}
+
+func (w *Watermark) stamp(img image.Image) image.Image {
+	b := img.Bounds()
+	markWidth := uint(float64(b.Dx()) * w.Scale)
+	if markWidth == 0 {
+		return img
+	}
+	mark := resize.Resize(markWidth, 0, w.mark, resize.Lanczos3)
+	size := mark.Bounds().Size()
+	margin := b.Dx() / 50
+	if b.Dy() < b.Dx() {
+		margin = b.Dy() / 50
+	}
+
+	var at image.Point
+	switch w.Position {
+	case PositionTopLeft:
+		at = image.Pt(b.Min.X+margin, b.Min.Y+margin)
+	case PositionTopRight:
+		at = image.Pt(b.Max.X-margin-size.X, b.Min.Y+margin)
+	case PositionBottomLeft:
+		at = image.Pt(b.Min.X+margin, b.Max.Y-margin-size.Y)
+	case PositionCenter:
+		at = image.Pt(b.Min.X+(b.Dx()-size.X)/2, b.Min.Y+(b.Dy()-size.Y)/2)
+	default:
+		at = image.Pt(b.Max.X-margin-size.X, b.Max.Y-margin-size.Y)
+	}
+
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	opacity := image.NewUniform(color.Alpha{A: uint8(w.Opacity * 255)})
+	draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(size)}, mark, mark.Bounds().Min, opacity, image.Point{}, draw.Over)
+	return dst
+}
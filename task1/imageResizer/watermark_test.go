@@ -0,0 +1,163 @@
+package imageResizer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Saves opaque red square to be used as watermark logo.
+func redLogo(t *testing.T) string {
+	logo := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+	path := filepath.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, logo); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSetWatermark(t *testing.T) {
+	s := newService()
+	logo := redLogo(t)
+
+	tests := []struct {
+		name    string
+		w       Watermark
+		wantErr bool
+	}{
+		{"image", Watermark{Image: logo}, false},
+		{"text", Watermark{Text: "example.com", Position: PositionCenter, Opacity: 1, Scale: 0.5}, false},
+		{"nothing", Watermark{}, true},
+		{"missing image", Watermark{Image: filepath.Join(t.TempDir(), "missing.png")}, true},
+		{"unknown position", Watermark{Text: "a", Position: "middle"}, true},
+		{"opacity", Watermark{Text: "a", Opacity: 2}, true},
+		{"scale", Watermark{Text: "a", Scale: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetWatermark(&tt.w)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Got %v, but expected error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWatermark_stamp(t *testing.T) {
+	s := newService()
+	gray := color.RGBA{128, 128, 128, 255}
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(img, img.Bounds(), image.NewUniform(gray), image.Point{}, draw.Src)
+
+	tests := []struct {
+		name     string
+		position string
+		opacity  float64
+		stamped  image.Point
+		clean    image.Point
+		want     color.RGBA
+	}{
+		// margin is 2 pixels, logo is 50x50
+		{"top left", PositionTopLeft, 1, image.Pt(10, 10), image.Pt(190, 90), color.RGBA{255, 0, 0, 255}},
+		{"bottom right", PositionBottomRight, 1, image.Pt(190, 90), image.Pt(10, 10), color.RGBA{255, 0, 0, 255}},
+		{"center", PositionCenter, 1, image.Pt(100, 50), image.Pt(10, 10), color.RGBA{255, 0, 0, 255}},
+		{"half opacity", PositionTopLeft, 0.5, image.Pt(10, 10), image.Pt(190, 90), color.RGBA{191, 64, 64, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.SetWatermark(&Watermark{Image: redLogo(t), Position: tt.position, Opacity: tt.opacity, Scale: 0.25})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stamped := s.watermark.apply(img)
+			got := color.RGBAModel.Convert(stamped.At(tt.stamped.X, tt.stamped.Y)).(color.RGBA)
+			if abs(int(got.R)-int(tt.want.R)) > 1 || abs(int(got.G)-int(tt.want.G)) > 1 {
+				t.Errorf("Got %v, but expected %v", got, tt.want)
+			}
+			if got := stamped.At(tt.clean.X, tt.clean.Y); got != color.Color(gray) {
+				t.Errorf("Got %v, but expected %v", got, gray)
+			}
+			// source image isn't changed
+			if got := img.At(tt.stamped.X, tt.stamped.Y); got != color.Color(gray) {
+				t.Errorf("Got %v, but expected unchanged image", got)
+			}
+		})
+	}
+}
+
+func TestImageResizer_watermark(t *testing.T) {
+	s := useTestService(t)
+	if err := s.SetWatermark(&Watermark{Image: redLogo(t), Opacity: 1, Original: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ir, err := imageResizerFromImagePath("testdata/PNGImage.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ir.Close()
+	if _, err := ir.SaveImages(); err != nil {
+		t.Fatal(err)
+	}
+	isRed := func(img image.Image) bool {
+		b := img.Bounds()
+		r, g, _, _ := img.At(b.Max.X-b.Dx()/10, b.Max.Y-b.Dy()/10).RGBA()
+		return r>>8 > 250 && g>>8 < 5
+	}
+
+	normal, _ := ir.GetNormalImg()
+	thumbnail, _ := ir.GetThumbnailImg()
+	if !isRed(normal) {
+		t.Error("Normal image isn't stamped")
+	}
+	if isRed(thumbnail) {
+		t.Error("Thumbnail is stamped")
+	}
+
+	meta, err := s.index.Get(ir.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := s.storage.Open(imageName(ir.ID(), OriginalVariant, PNG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+	img, err := png.Decode(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isRed(img) || meta.Source == "" {
+		t.Errorf("Got source %q, but expected stamped original and source without watermark", meta.Source)
+	}
+
+	// variants rendered later aren't stamped twice
+	loaded, err := s.LoadImageResizer(ir.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if isRed(loaded.GetOriginalImg()) {
+		t.Error("Image is loaded from stamped original")
+	}
+	if thumbnail, _ := loaded.GetThumbnailImg(); isRed(thumbnail) {
+		t.Error("Thumbnail is stamped")
+	}
+
+	if err := s.DeleteImage(ir.ID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.storage.Open(meta.Source); !os.IsNotExist(err) {
+		t.Errorf("Got %v, but expected deleted source", err)
+	}
+}
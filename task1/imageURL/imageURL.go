@@ -0,0 +1,106 @@
+// Package imageURL mints and verifies signed URLs of images served by
+// imageResizer. Signed URL is valid until its expiry time and only with
+// the key it was signed with, so other services sharing the key, e.g.
+// discussion service, can give out links to images without asking
+// imageResizer.
+package imageURL
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// query parameters of signed URL
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+var (
+	ErrNoSignature      = errors.New("URL isn't signed!!!")
+	ErrInvalidSignature = errors.New("Invalid URL signature!!!")
+	ErrExpired          = errors.New("URL expired!!!")
+)
+
+// Signer signs URLs of images served at baseURL.
+type Signer struct {
+	baseURL string
+	key     []byte
+}
+
+// Returns Signer of URLs of imageResizer server at baseURL,
+// e.g. "https://images.example.com".
+func NewSigner(baseURL string, key []byte) *Signer {
+	return &Signer{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		key:     key,
+	}
+}
+
+// Returns signed URL of saved image variant valid for ttl.
+// Original image is returned if preset is empty.
+func (s *Signer) URL(id, preset string, ttl time.Duration) string {
+	if preset == "" {
+		preset = "original"
+	}
+	u, _ := s.Sign(s.baseURL+"/images/"+url.PathEscape(id)+"/"+url.PathEscape(preset), time.Now().Add(ttl))
+	return u
+}
+
+// Returns rawURL signed until expires. Query of rawURL is signed too, so it
+// can't be changed, e.g. size of image resized on the fly.
+func (s *Signer) Sign(rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del(SignatureParam)
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(SignatureParam, s.signature(u.EscapedPath(), query))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Returns nil if URL is signed by the key and not expired at now.
+func (s *Signer) Verify(u *url.URL, now time.Time) error {
+	query := u.Query()
+	signature := query.Get(SignatureParam)
+	if signature == "" {
+		return ErrNoSignature
+	}
+	expected := s.signature(u.EscapedPath(), query)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Returns signature of path and query without signature parameter. Only
+// path is signed, so URL stays valid behind proxies which change host.
+func (s *Signer) signature(path string, query url.Values) string {
+	signed := url.Values{}
+	for key, values := range query {
+		if key != SignatureParam {
+			signed[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "?" + signed.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
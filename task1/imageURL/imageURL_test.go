@@ -0,0 +1,73 @@
+package imageURL
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := NewSigner("http://localhost:8080/", []byte("secret"))
+	now := time.Now()
+	signed, err := s.Sign("http://localhost:8080/image/abc?w=100&h=100", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		signer  *Signer
+		now     time.Time
+		wantErr error
+	}{
+		{"valid", signed, s, now, nil},
+		{"other host", strings.Replace(signed, "localhost:8080", "images.example.com", 1), s, now, nil},
+		{"expired", signed, s, now.Add(2 * time.Hour), ErrExpired},
+		{"other path", strings.Replace(signed, "/abc", "/abd", 1), s, now, ErrInvalidSignature},
+		{"other query", strings.Replace(signed, "w=100", "w=1000", 1), s, now, ErrInvalidSignature},
+		{"added query", signed + "&fit=fill", s, now, ErrInvalidSignature},
+		{"other expiry", strings.Replace(signed, "expires=", "expires=9", 1), s, now, ErrInvalidSignature},
+		{"other key", signed, NewSigner("http://localhost:8080", []byte("other")), now, ErrInvalidSignature},
+		{"not signed", "http://localhost:8080/image/abc?w=100&h=100", s, now, ErrNoSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.signer.Verify(u, tt.now); err != tt.wantErr {
+				t.Errorf("Got %v, but expected %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigner_URL(t *testing.T) {
+	s := NewSigner("http://localhost:8080", []byte("secret"))
+
+	tests := []struct {
+		preset   string
+		wantPath string
+	}{
+		{"", "/images/abc/original"},
+		{"thumbnail", "/images/abc/thumbnail"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(s.URL("abc", tt.preset, time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Path != tt.wantPath {
+			t.Errorf("Got %v, but expected %v", u.Path, tt.wantPath)
+		}
+		if err := s.Verify(u, time.Now()); err != nil {
+			t.Errorf("Got %v, but expected valid URL", err)
+		}
+		if err := s.Verify(u, time.Now().Add(2*time.Minute)); err != ErrExpired {
+			t.Errorf("Got %v, but expected %v", err, ErrExpired)
+		}
+	}
+}
@@ -1,27 +1,85 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/dairovolzhas/dar-internship/task1/imageResizer"
-	"github.com/gorilla/mux"
 	"log"
 	"net/http"
-)
-
-var (
-	port = "8080"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 
 
 func main(){
 
-	r := mux.NewRouter()
+	// settings are read from CONFIG_FILE if it's set and from
+	// environment variables, see imageResizer.Config
+	config, err := imageResizer.LoadConfig(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	r.Methods("POST").Path("/image").HandlerFunc(imageResizer.ImageProcessingHandler)
+	if len(os.Args) > 1 && os.Args[1] == "process" {
+		os.Exit(process(config, os.Args[2:]))
+	}
+	serve(config)
+}
 
-	fmt.Printf("Server started at localhost:%s\n", port)
+// Runs HTTP server until SIGTERM or SIGINT, then waits for
+// in-flight requests and jobs to finish. Spans are exported
+// if TraceEndpoint is set.
+func serve(config imageResizer.Config) {
+	service, err := imageResizer.NewService(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Fatal(http.ListenAndServe(":"+port, r))
-}
+	stopTracing := func(context.Context) error { return nil }
+	if config.TraceEndpoint != "" {
+		if stopTracing, err = startTracing(config.TraceEndpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	server := &http.Server{
+		Addr:         ":" + config.Port,
+		Handler:      service.Handler(),
+		ReadTimeout:  time.Duration(config.ReadTimeout),
+		WriteTimeout: time.Duration(config.WriteTimeout),
+		IdleTimeout:  time.Duration(config.IdleTimeout),
+	}
 
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	failed := make(chan error, 1)
+	go func() {
+		failed <- server.ListenAndServe()
+	}()
+
+	fmt.Printf("Server started at localhost:%s\n", config.Port)
+
+	select {
+	case err := <-failed:
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("Got %v, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout))
+	defer cancel()
+	// new connections are refused at once, requests which are
+	// processed now are waited for
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print("Server shutdown: ", err)
+	}
+	if err := service.Shutdown(ctx); err != nil {
+		log.Print("Jobs shutdown: ", err)
+	}
+	if err := stopTracing(ctx); err != nil {
+		log.Print("Tracing shutdown: ", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dairovolzhas/dar-internship/task1/imageResizer"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Runs "process" command which renders presets of every image in directory:
+//
+//	imageresizer process --in dir --out dir [--preset normal,thumbnail] [--workers n] [--force]
+//
+// Returns exit code.
+func process(config imageResizer.Config, args []string) int {
+	flags := flag.NewFlagSet("process", flag.ContinueOnError)
+	in := flags.String("in", "", "directory with images")
+	out := flags.String("out", "", "directory where resized images are written")
+	names := flags.String("preset", "", "comma separated presets to render, all presets if empty")
+	workers := flags.Int("workers", runtime.NumCPU(), "number of images processed at once")
+	force := flags.Bool("force", false, "render images which are up to date too")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *in == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "process: --in and --out are required")
+		flags.Usage()
+		return 2
+	}
+
+	// images are only written to --out, nothing is stored or queued
+	config.IndexFile = ""
+	config.JobsDir = ""
+	service, err := imageResizer.NewService(config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "process:", err)
+		return 1
+	}
+
+	presets, err := selectPresets(service.Presets(), *names)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "process:", err)
+		return 2
+	}
+
+	summary, err := service.ProcessDir(imageResizer.ProcessOptions{
+		In:      *in,
+		Out:     *out,
+		Presets: presets,
+		Workers: *workers,
+		Force:   *force,
+		Log:     os.Stderr,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "process:", err)
+		return 1
+	}
+	fmt.Println(summary)
+
+	if summary.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Returns presets with given comma separated names, all presets if names is empty.
+func selectPresets(presets []imageResizer.Preset, names string) ([]imageResizer.Preset, error) {
+	if names == "" {
+		return presets, nil
+	}
+	var selected []imageResizer.Preset
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, p := range presets {
+			if p.Name == name {
+				selected = append(selected, p)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown preset %q", name)
+		}
+	}
+	return selected, nil
+}
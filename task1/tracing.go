@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Sends spans of the service to OTLP/HTTP collector at endpoint in batches.
+// Returned function sends spans which are left, it's called on shutdown.
+func startTracing(endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "imageResizer"))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}